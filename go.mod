@@ -1,6 +1,6 @@
 module github.com/frohmut/musql
 
-go 1.16
+go 1.17
 
 require (
 	github.com/antchfx/jsonquery v1.1.4
@@ -8,4 +8,10 @@ require (
 	github.com/antchfx/xpath v1.1.11
 	github.com/frohmut/mustache v1.0.2-0.20210301123205-1c1d9a8ce37b
 	github.com/mattn/go-sqlite3 v1.14.6
+	golang.org/x/text v0.3.8
+)
+
+require (
+	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
+	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc // indirect
 )
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// split a command line into the arguments, single and double
+// quotes group words (there is no shell involved)
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inarg := false
+	var quote rune
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inarg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inarg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inarg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inarg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing quote in command '%s'", s)
+	}
+	if inarg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// run the command of the source and return its standard output
+func commandOutput(info FileInfo) ([]byte, error) {
+	args, err := splitCommand(info.Path)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = info.Dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return nil, fmt.Errorf("%w: running '%s': %s", err, info.Path, msg)
+		}
+		return nil, fmt.Errorf("%w: running '%s'", err, info.Path)
+	}
+	return out, nil
+}
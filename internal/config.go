@@ -1,13 +1,16 @@
 package internal
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -15,14 +18,22 @@ type tabinfo struct {
 	Tablename string
 	Files     []FileInfo
 	Type      string
+	Options   ImportOptions
 	// csv
-	Sep    rune
-	Header []string
+	Csv CsvDialect
+	// xlsx
+	Sheet string
+	Range string
+	// text files
+	Regex string
+	Lines LineOptions
+	Fixed []FixedField
 	// files
-	Content bool
+	FileOpts FileOptions
 	// xml
-	XPath   string
-	XSelect []Select
+	XPath    string
+	XSelect  []Select
+	TreeOpts TreeOptions
 }
 
 type templinfo struct {
@@ -32,11 +43,45 @@ type templinfo struct {
 }
 
 func getPath(basedir string, fname string) string {
+	if filepath.IsAbs(fname) || fname == stdinName || isURL(fname) {
+		return fname
+	}
 	return path.Join(basedir, fname)
 }
 
+// read a (possibly quoted) argument: ini files are split into words
+// at the spaces and keep the quotes, so the words up to the closing
+// quote are joined again
+func quotedArg(argv []string, i int) (string, int, error) {
+	s := argv[i]
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		return s, i + 1, nil
+	}
+	q := s[:1]
+	for len(s) < 2 || !strings.HasSuffix(s, q) {
+		i++
+		if i >= len(argv) {
+			return "", i, fmt.Errorf("Missing closing quote for " + s)
+		}
+		s = s + " " + argv[i]
+	}
+	return s[1 : len(s)-1], i + 1, nil
+}
+
 func ArgSource(argv []string, start int, basedir string, tables *[]*tabinfo) (int, error) {
-	// insert {<filename>} into <name> [as <type>]
+	// insert {<filename> [from <container>] | command <cmdline>} into <name> [append | upsert on (<keys>)]  ("-" reads stdin)
+	//   [as <type>] [sheet <sheet>] [range <cells>]    (types xmltree/jsontree: a row per node)
+	//   [as regex <expr> {continuation [to <group>] | linenumbers}]
+	//   [as fixed with {<name>[:<type>]:<start>-<end>}]
+	//   [separator {<sep> | auto}] {quote <char> | comment <char> | lazy quotes | trim fields | variable fields
+	//     | skip lines <n> | skip footer <n>} [encoding <name>] {locale <locale> [for <col>{,<col>}]}
+	//   [untyped] [merge columns]
+	//   {header <"name: value"> | timeout <seconds> | cache <dir>}    (urls are only cached with a cache dir)
+	//   {include <glob> | exclude <glob> | maxdepth <n> | maxsize <bytes>[k|m|g]}
+	//   {with (content | hash | provenance | children | {skip | <name>[:<type>[:<rename>]]} as (header | columns))}
+	//   {namespace <prefix>=<uri>} [keep prefixes]
+	//   [using {<xpath> [as <name>] [<repeated>]} from xpath | <repeated>] [xpath <xpath>]
+	//   with <repeated>: repeated (index | first | json | join <delim>)
 	t := &tabinfo{}
 	i := start
 	if start >= len(argv) || (argv[start] != "insert" && argv[start] != "-insert") {
@@ -45,6 +90,18 @@ func ArgSource(argv []string, start int, basedir string, tables *[]*tabinfo) (in
 	i++
 	for i < len(argv) && argv[i] != "into" {
 		f := FileInfo{}
+		if argv[i] == "command" {
+			if i+1 >= len(argv) {
+				return start, fmt.Errorf("Missing command line after 'command'")
+			}
+			cmdline, next, err := quotedArg(argv, i+1)
+			if err != nil {
+				return start, err
+			}
+			t.Files = append(t.Files, FileInfo{Path: cmdline, Command: true, Dir: basedir})
+			i = next
+			continue
+		}
 		f.Path = getPath(basedir, argv[i])
 		i++
 		if i < len(argv) && argv[i] == "from" {
@@ -63,6 +120,31 @@ func ArgSource(argv []string, start int, basedir string, tables *[]*tabinfo) (in
 	i++
 	t.Tablename = argv[i]
 	i++
+	if i < len(argv) && argv[i] == "append" {
+		i++
+		t.Options.Mode = ModeAppend
+	} else if i < len(argv) && argv[i] == "upsert" {
+		i++
+		if i >= len(argv) || argv[i] != "on" {
+			return start, fmt.Errorf("Missing 'on (<keys>)' after 'upsert'")
+		}
+		i++
+		// the key list may be split into several words
+		keys := ""
+		for i < len(argv) && !strings.HasSuffix(keys, ")") {
+			keys += argv[i]
+			i++
+		}
+		if !strings.HasPrefix(keys, "(") || !strings.HasSuffix(keys, ")") {
+			return start, fmt.Errorf("Missing '(<keys>)' after 'upsert on'")
+		}
+		t.Options.Mode = ModeUpsert
+		for _, k := range strings.Split(keys[1:len(keys)-1], ",") {
+			if k != "" {
+				t.Options.Keys = append(t.Options.Keys, k)
+			}
+		}
+	}
 	if i < len(argv) && argv[i] == "as" {
 		i++
 		if i >= len(argv) {
@@ -70,6 +152,77 @@ func ArgSource(argv []string, start int, basedir string, tables *[]*tabinfo) (in
 		}
 		t.Type = argv[i]
 		i++
+		if t.Type == "regex" {
+			if i >= len(argv) {
+				return start, fmt.Errorf("Missing regular expression after 'regex'")
+			}
+			var err error
+			t.Regex, i, err = quotedArg(argv, i)
+			if err != nil {
+				return start, err
+			}
+			re, err := regexp.Compile(t.Regex)
+			if err != nil {
+				return start, err
+			}
+			for i < len(argv) && (argv[i] == "continuation" || argv[i] == "linenumbers") {
+				if argv[i] == "linenumbers" {
+					t.Lines.LineNumbers = true
+					i++
+					continue
+				}
+				i++
+				if i+1 < len(argv) && argv[i] == "to" {
+					t.Lines.Continuation = argv[i+1]
+					i += 2
+				} else {
+					// default: the last named group
+					for _, name := range re.SubexpNames() {
+						if name != "" {
+							t.Lines.Continuation = name
+						}
+					}
+				}
+			}
+		}
+		if t.Type == "fixed" {
+			if i >= len(argv) || argv[i] != "with" {
+				return start, fmt.Errorf("Missing 'with' and fields after 'fixed'")
+			}
+			i++
+			for i < len(argv) && IsFixedField(argv[i]) {
+				f, c, err := ParseFixedField(argv[i])
+				if err != nil {
+					return start, err
+				}
+				t.Fixed = append(t.Fixed, f)
+				t.Options.Columns = append(t.Options.Columns, c)
+				i++
+			}
+			if len(t.Fixed) == 0 {
+				return start, fmt.Errorf("Missing fields after 'fixed with'")
+			}
+			t.Options.KeepHeader = true
+		}
+	}
+	if i < len(argv) && argv[i] == "sheet" {
+		i++
+		if i >= len(argv) {
+			return start, fmt.Errorf("Missing sheet name after 'sheet'")
+		}
+		var err error
+		t.Sheet, i, err = quotedArg(argv, i)
+		if err != nil {
+			return start, err
+		}
+	}
+	if i < len(argv) && argv[i] == "range" {
+		i++
+		if i >= len(argv) {
+			return start, fmt.Errorf("Missing cell range after 'range'")
+		}
+		t.Range = argv[i]
+		i++
 	}
 
 	if i < len(argv) && argv[i] == "separator" {
@@ -77,55 +230,206 @@ func ArgSource(argv []string, start int, basedir string, tables *[]*tabinfo) (in
 		if i >= len(argv) {
 			return start, fmt.Errorf("Missing separator after 'separator'")
 		}
-		t.Sep, _ = utf8.DecodeRuneInString(argv[i])
+		if argv[i] == "auto" {
+			t.Csv.Sniff = true
+		} else {
+			t.Csv.Sep, _ = utf8.DecodeRuneInString(argv[i])
+		}
 		i++
 	}
-	if i < len(argv) && argv[i] == "with" {
+	for {
+		next, err := argCsvDialect(argv, i, &t.Csv)
+		if err != nil {
+			return start, err
+		}
+		if next == i {
+			break
+		}
+		i = next
+	}
+	if i+1 < len(argv) && argv[i] == "encoding" {
+		_, err := textEncoding(argv[i+1])
+		if err != nil {
+			return start, err
+		}
+		for fi := range t.Files {
+			t.Files[fi].Encoding = argv[i+1]
+		}
+		i += 2
+	}
+	for i < len(argv) && argv[i] == "locale" {
+		i++
+		if i >= len(argv) {
+			return start, fmt.Errorf("Missing name after 'locale'")
+		}
+		name := argv[i]
+		_, err := ParseLocale(name)
+		if err != nil {
+			return start, err
+		}
+		i++
+		if i < len(argv) && argv[i] == "for" {
+			i++
+			if i >= len(argv) {
+				return start, fmt.Errorf("Missing columns after 'locale %s for'", name)
+			}
+			if t.Options.ColumnLocales == nil {
+				t.Options.ColumnLocales = make(map[string]string)
+			}
+			for _, col := range strings.Split(argv[i], ",") {
+				t.Options.ColumnLocales[col] = name
+			}
+			i++
+			continue
+		}
+		t.Options.Locale = name
+	}
+	if i < len(argv) && argv[i] == "untyped" {
+		i++
+		t.Options.Untyped = true
+	}
+	if i+1 < len(argv) && argv[i] == "merge" && argv[i+1] == "columns" {
+		i += 2
+		t.Options.MergeColumns = true
+	}
+	var hopts HTTPOptions
+	for i+1 < len(argv) && (argv[i] == "header" || argv[i] == "timeout" || argv[i] == "cache") {
+		switch argv[i] {
+		case "header":
+			h, next, err := quotedArg(argv, i+1)
+			if err != nil {
+				return start, err
+			}
+			hopts.Headers = append(hopts.Headers, h)
+			i = next
+			continue
+		case "timeout":
+			n, err := strconv.ParseFloat(argv[i+1], 64)
+			if err != nil || n <= 0 {
+				return start, fmt.Errorf("Bad seconds '%s' after 'timeout'", argv[i+1])
+			}
+			hopts.Timeout = time.Duration(n * float64(time.Second))
+		case "cache":
+			hopts.CacheDir = getPath(basedir, argv[i+1])
+		}
+		i += 2
+	}
+	for fi := range t.Files {
+		if isURL(t.Files[fi].Path) {
+			t.Files[fi].HTTP = hopts
+		}
+	}
+	for i+1 < len(argv) && (argv[i] == "include" || argv[i] == "exclude" || argv[i] == "maxdepth" || argv[i] == "maxsize") {
+		switch argv[i] {
+		case "include":
+			t.FileOpts.Include = append(t.FileOpts.Include, argv[i+1])
+		case "exclude":
+			t.FileOpts.Exclude = append(t.FileOpts.Exclude, argv[i+1])
+		case "maxdepth":
+			n, err := strconv.Atoi(argv[i+1])
+			if err != nil || n < 1 {
+				return start, fmt.Errorf("Bad depth '%s' after 'maxdepth'", argv[i+1])
+			}
+			t.FileOpts.MaxDepth = n
+		case "maxsize":
+			n, err := parseSize(argv[i+1])
+			if err != nil {
+				return start, fmt.Errorf("Bad size '%s' after 'maxsize'", argv[i+1])
+			}
+			t.FileOpts.MaxSize = n
+		}
+		i += 2
+	}
+	for i < len(argv) && argv[i] == "with" {
 		i++
 		if i < len(argv) && argv[i] == "content" {
 			i++
-			t.Content = true
+			t.FileOpts.Content = true
+		} else if i < len(argv) && argv[i] == "hash" {
+			i++
+			t.FileOpts.Hash = true
+		} else if i < len(argv) && argv[i] == "provenance" {
+			i++
+			t.Options.Provenance = true
+		} else if i < len(argv) && argv[i] == "children" {
+			i++
+			t.TreeOpts.Children = true
 		} else {
 			for i < len(argv) && argv[i] != "as" {
-				t.Header = append(t.Header, argv[i])
+				c, err := ParseColumn(argv[i])
+				if err != nil {
+					return start, err
+				}
+				t.Options.Columns = append(t.Options.Columns, c)
 				i++
 			}
-			if i+1 >= len(argv)+1 || argv[i] != "as" || argv[i+1] != "header" {
-				return start, fmt.Errorf("Missing 'content' or 'as header' after 'with'")
+			if i+1 >= len(argv) || argv[i] != "as" || (argv[i+1] != "header" && argv[i+1] != "columns") {
+				return start, fmt.Errorf("Missing 'content', 'hash', 'provenance', 'children', 'as header' or 'as columns' after 'with'")
 			}
+			// 'as columns': the file has its own header line
+			t.Options.KeepHeader = argv[i+1] == "columns"
 			i++
 			i++
 		}
-	} else {
-		if i < len(argv) && argv[i] == "using" {
+	}
+	for i+1 < len(argv) && argv[i] == "namespace" {
+		prefix, uri, err := ParseNamespace(argv[i+1])
+		if err != nil {
+			return start, err
+		}
+		if t.TreeOpts.Namespaces == nil {
+			t.TreeOpts.Namespaces = make(map[string]string)
+		}
+		t.TreeOpts.Namespaces[prefix] = uri
+		i += 2
+	}
+	if i+1 < len(argv) && argv[i] == "keep" && argv[i+1] == "prefixes" {
+		i += 2
+		t.TreeOpts.KeepPrefixes = true
+	}
+	if i < len(argv) && argv[i] == "using" {
+		i++
+		for i < len(argv) && argv[i] != "from" {
+			s := Select{}
+			s.Path = argv[i]
 			i++
-			for i < len(argv) && argv[i] != "from" {
-				s := Select{}
-				s.Path = argv[i]
+			if i < len(argv) && argv[i] == "as" {
 				i++
-				if i < len(argv) && argv[i] == "as" {
-					i++
-					if i >= len(argv) {
-						return start, fmt.Errorf("Missing name after 'as'")
-					}
-					s.Name = argv[i]
-					i++
+				if i >= len(argv) {
+					return start, fmt.Errorf("Missing name after 'as'")
 				}
-				t.XSelect = append(t.XSelect, s)
+				s.Name = argv[i]
+				i++
 			}
-			if i >= len(argv)-1 || argv[i] != "from" || argv[i+1] != "xpath" {
-				return start, fmt.Errorf("Missing 'from xpath' after 'using' list")
+			var err error
+			i, err = argRepeat(argv, i, &s)
+			if err != nil {
+				return start, err
 			}
-			i++
+			t.XSelect = append(t.XSelect, s)
 		}
-		if i < len(argv) && argv[i] == "xpath" {
-			i++
-			if i >= len(argv) {
-				return start, fmt.Errorf("Missing xpath after 'xpath'")
-			}
-			t.XPath = argv[i]
-			i++
+		if i >= len(argv)-1 || argv[i] != "from" || argv[i+1] != "xpath" {
+			return start, fmt.Errorf("Missing 'from xpath' after 'using' list")
 		}
+		i++
+	}
+	if len(t.XSelect) == 0 && i < len(argv) && argv[i] == "repeated" {
+		// for the whole node
+		s := Select{Path: "."}
+		var err error
+		i, err = argRepeat(argv, i, &s)
+		if err != nil {
+			return start, err
+		}
+		t.XSelect = append(t.XSelect, s)
+	}
+	if i < len(argv) && argv[i] == "xpath" {
+		i++
+		if i >= len(argv) {
+			return start, fmt.Errorf("Missing xpath after 'xpath'")
+		}
+		t.XPath = argv[i]
+		i++
 	}
 
 	*tables = append(*tables, t)
@@ -244,6 +548,111 @@ func ArgDB(argv []string, i int, basedir string, s *string) (int, error) {
 	return i, nil
 }
 
+// one of the csv dialect options
+func argCsvDialect(argv []string, i int, d *CsvDialect) (int, error) {
+	if i+1 >= len(argv) {
+		return i, nil
+	}
+	switch argv[i] {
+	case "quote", "comment":
+		c, n := utf8.DecodeRuneInString(argv[i+1])
+		if n == 0 || n != len(argv[i+1]) {
+			return i, fmt.Errorf("Bad character '%s' after '%s'", argv[i+1], argv[i])
+		}
+		if argv[i] == "quote" {
+			d.Quote = c
+		} else {
+			d.Comment = c
+		}
+		return i + 2, nil
+	case "lazy":
+		if argv[i+1] == "quotes" {
+			d.LazyQuotes = true
+			return i + 2, nil
+		}
+	case "trim":
+		if argv[i+1] == "fields" {
+			d.Trim = true
+			return i + 2, nil
+		}
+	case "variable":
+		if argv[i+1] == "fields" {
+			d.VariableFields = true
+			return i + 2, nil
+		}
+	case "skip":
+		if (argv[i+1] == "lines" || argv[i+1] == "footer") && i+2 < len(argv) {
+			n, err := strconv.Atoi(argv[i+2])
+			if err != nil || n < 0 {
+				return i, fmt.Errorf("Bad number '%s' after 'skip %s'", argv[i+2], argv[i+1])
+			}
+			if argv[i+1] == "lines" {
+				d.SkipLines = n
+			} else {
+				d.SkipFooter = n
+			}
+			return i + 3, nil
+		}
+	}
+	return i, nil
+}
+
+// how repeated elements of a select are stored:
+// repeated (index | first | json | join <delim>)
+func argRepeat(argv []string, i int, s *Select) (int, error) {
+	if i >= len(argv) || argv[i] != "repeated" {
+		return i, nil
+	}
+	i++
+	if i >= len(argv) {
+		return i, fmt.Errorf("Missing 'index', 'first', 'json' or 'join' after 'repeated'")
+	}
+	switch argv[i] {
+	case RepeatIndex, RepeatFirst, RepeatJSON:
+		s.Repeat = argv[i]
+		return i + 1, nil
+	case RepeatJoin:
+		if i+1 >= len(argv) {
+			return i, fmt.Errorf("Missing delimiter after 'repeated join'")
+		}
+		s.Repeat = RepeatJoin
+		var err error
+		s.Delim, i, err = quotedArg(argv, i+1)
+		return i, err
+	}
+	return i, fmt.Errorf("Unknown option '%s' after 'repeated'", argv[i])
+}
+
+// allow sources running commands, only on the command line
+// (ini files could come from anywhere)
+func ArgAllowCommands(argv []string, i int, _ string, inini bool, b *bool) (int, error) {
+	if i+1 < len(argv) && argv[i] == "allow" && argv[i+1] == "commands" {
+		if inini {
+			return i, fmt.Errorf("'allow commands' is only accepted on the command line")
+		}
+		i += 2
+		*b = true
+	}
+	return i, nil
+}
+
+// skip the import of tables with unchanged files (for db files)
+func ArgIncremental(argv []string, i int, _ string, b *bool) (int, error) {
+	if i < len(argv) && argv[i] == "incremental" {
+		i++
+		*b = true
+	}
+	return i, nil
+}
+
+func ArgVerbose(argv []string, i int, _ string, b *bool) (int, error) {
+	if i < len(argv) && argv[i] == "verbose" {
+		i++
+		*b = true
+	}
+	return i, nil
+}
+
 func ArgAttach(argv []string, i int, basedir string, a map[string]string) (int, error) {
 	if i >= len(argv) || argv[i] != "attach" {
 		return i, nil
@@ -309,6 +718,10 @@ type Config struct {
 	templates    []*templinfo
 	sqls         []string
 	dbname       string
+	incremental  bool
+	allowcmds    bool
+	verbose      bool
+	inini        bool
 	params       map[string]string
 	dbs          map[string]string
 	parsers      []Parser
@@ -332,6 +745,11 @@ func (c *Config) Init() {
 	c.AddParser(func(argv []string, i int, b string) (int, error) { return ArgAttach(argv, i, b, c.dbs) })
 	c.AddParser(func(argv []string, i int, b string) (int, error) { return ArgIni(argv, i, b, &c.allargs) })
 	c.AddParser(func(argv []string, i int, b string) (int, error) { return ArgDB(argv, i, b, &c.dbname) })
+	c.AddParser(func(argv []string, i int, b string) (int, error) { return ArgIncremental(argv, i, b, &c.incremental) })
+	c.AddParser(func(argv []string, i int, b string) (int, error) { return ArgVerbose(argv, i, b, &c.verbose) })
+	c.AddParser(func(argv []string, i int, b string) (int, error) {
+		return ArgAllowCommands(argv, i, b, c.inini, &c.allowcmds)
+	})
 	c.AddParser(ArgIgnoreComment)
 	c.AddParser(ArgIgnoreEmpty)
 }
@@ -348,6 +766,7 @@ func (c *Config) Parse(argv []string) (err error) {
 	for pi := 0; pi < len(c.allargs.parts); pi++ {
 		av := c.allargs.parts[pi].argv
 		bd := c.allargs.parts[pi].basedir
+		c.inini = pi > 0
 		i := 0
 		for {
 			curr := i
@@ -383,28 +802,65 @@ func (c *Config) Apply(m *Musql) (err error) {
 	if err != nil {
 		return err
 	}
+	if c.verbose {
+		m.verbose = os.Stderr
+	}
+
+	for _, t := range c.tabinfos {
+		for _, f := range t.Files {
+			if f.Command && !c.allowcmds {
+				return fmt.Errorf("not running '%s' for table %s: commands need 'allow commands' on the command line", f.Path, t.Tablename)
+			}
+		}
+	}
+	// commands and urls run again for every run
+	m.sources = nil
 
 	for _, t := range c.tabinfos {
 		var err error
 		var stat os.FileInfo
-		if t.XPath != "" {
-			if t.Type == "xml" || (t.Type == "" && len(t.Files) > 0 && strings.HasSuffix(t.Files[0].Path, ".xml")) {
-				err = m.AddXml(t.Tablename, t.Files, t.XPath, t.XSelect)
-			} else {
-				err = m.AddJson(t.Tablename, t.Files, t.XPath, t.XSelect)
+		var states []sourceState
+		signature := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%v", *t))))
+		if c.incremental && c.dbname != "" {
+			var unchanged bool
+			unchanged, states, err = m.sourcesUnchanged(t.Tablename, t.Files, signature)
+			if err != nil {
+				return err
 			}
-		} else if stat, err = os.Stat(t.Files[0].Path); len(t.Files) == 1 && err == nil && stat.IsDir() {
-			err = m.AddFiles(t.Tablename, t.Files[0].Path, t.Content)
-		} else {
-			if len(t.Header) > 0 {
-				err = m.AddCsvWithHeader(t.Tablename, t.Files, t.Sep, t.Header)
+			if unchanged {
+				continue
+			}
+		}
+		if t.Type == "xmltree" || t.Type == "jsontree" {
+			err = m.AddTree(t.Tablename, t.Files, strings.TrimSuffix(t.Type, "tree"), t.TreeOpts, t.Options)
+		} else if t.Type == "jsonl" || (t.Type == "" && filesHaveExtension(t.Files, ".jsonl", ".ndjson")) {
+			err = m.AddJsonl(t.Tablename, t.Files, t.XPath, t.XSelect, t.Options)
+		} else if t.Type == "regex" {
+			err = m.AddRegex(t.Tablename, t.Files, t.Regex, t.Lines, t.Options)
+		} else if t.Type == "fixed" {
+			err = m.AddFixed(t.Tablename, t.Files, t.Fixed, t.Options)
+		} else if t.XPath != "" {
+			if t.Type == "xml" || (t.Type == "" && filesHaveExtension(t.Files, ".xml")) {
+				err = m.AddFromTreeFile(t.Tablename, t.Files, t.XPath, t.XSelect, "xml", t.TreeOpts, t.Options)
 			} else {
-				err = m.AddCsv(t.Tablename, t.Files, t.Sep)
+				err = m.AddFromTreeFile(t.Tablename, t.Files, t.XPath, t.XSelect, "json", t.TreeOpts, t.Options)
 			}
+		} else if t.Type == "xlsx" || (t.Type == "" && filesHaveExtension(t.Files, ".xlsx")) {
+			err = m.AddXlsx(t.Tablename, t.Files, t.Sheet, t.Range, t.Options)
+		} else if stat, err = os.Stat(t.Files[0].Path); len(t.Files) == 1 && !t.Files[0].Command && err == nil && stat.IsDir() {
+			err = m.AddFiles(t.Tablename, t.Files[0].Path, t.FileOpts, t.Options)
+		} else {
+			err = m.AddCsvWithOptions(t.Tablename, t.Files, t.Csv, t.Options)
 		}
 		if err != nil {
 			return err
 		}
+		if states != nil {
+			err = m.recordSources(t.Tablename, signature, states, t.Options.Mode == ModeReplace)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	err = m.AddParameters("parameter", c.params)
 	if err != nil {
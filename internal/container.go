@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// file name of the standard input
+const stdinName = "-"
+
+// the standard input (kept in Musql.sources after the first read)
+var stdin io.Reader = os.Stdin
+
+func readStdin() ([]byte, error) {
+	b, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading standard input", err)
+	}
+	return b, nil
+}
+
+type FileContainer struct {
+	file io.ReadCloser
+	// closed after file (container, decompressors), last one first
+	closers []io.Closer
+}
+
+// file name without the extension of a compression format
+func uncompressedName(name string) string {
+	for _, ext := range []string{".gz", ".bz2"} {
+		if strings.HasSuffix(name, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// check the extension of a (possibly compressed) file
+func hasExtension(name string, ext string) bool {
+	return strings.HasSuffix(uncompressedName(name), ext)
+}
+
+// check the extension of the first file, commands have no extension
+func filesHaveExtension(files []FileInfo, exts ...string) bool {
+	if len(files) == 0 || files[0].Command {
+		return false
+	}
+	for _, ext := range exts {
+		if hasExtension(files[0].Path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTar(name string) bool {
+	return hasExtension(name, ".tar") || strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tbz2")
+}
+
+// decompress the content of .gz and .bz2 files
+func (f *FileContainer) decompress(name string) error {
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		zr, err := gzip.NewReader(f.file)
+		if err != nil {
+			return fmt.Errorf("%w: decompressing %s", err, name)
+		}
+		f.closers = append(f.closers, f.file)
+		f.file = zr
+	} else if strings.HasSuffix(name, ".bz2") || strings.HasSuffix(name, ".tbz2") {
+		f.closers = append(f.closers, f.file)
+		f.file = ioutil.NopCloser(bzip2.NewReader(f.file))
+	}
+	return nil
+}
+
+// the decoder of a character encoding, nil for utf-8
+func textEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252, nil
+	case "iso-8859-1", "latin1":
+		return charmap.ISO8859_1, nil
+	case "iso-8859-15", "latin9":
+		return charmap.ISO8859_15, nil
+	case "utf-16", "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
+	}
+	return nil, fmt.Errorf("unknown encoding '%s'", name)
+}
+
+// transcode the content to utf-8. Without an encoding a byte
+// order mark tells if the text is utf-16, an utf-8 one is removed.
+func (f *FileContainer) decode(name string, enc string) error {
+	decoder, err := textEncoding(enc)
+	if err != nil {
+		return fmt.Errorf("%w: reading %s", err, name)
+	}
+	br := bufio.NewReader(f.file)
+	var r io.Reader = br
+	if decoder == nil {
+		bom, _ := br.Peek(3)
+		if bytes.HasPrefix(bom, []byte{0xef, 0xbb, 0xbf}) {
+			br.Discard(3)
+		} else if bytes.HasPrefix(bom, []byte{0xff, 0xfe}) || bytes.HasPrefix(bom, []byte{0xfe, 0xff}) {
+			decoder = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+		}
+	}
+	if decoder != nil {
+		r = transform.NewReader(br, decoder.NewDecoder())
+	}
+	f.closers = append(f.closers, f.file)
+	f.file = ioutil.NopCloser(r)
+	return nil
+}
+
+// names in archives may start with "./"
+func memberName(name string) string {
+	name = path.Clean(name)
+	return strings.TrimPrefix(name, "/")
+}
+
+func (f *FileContainer) openZipMember(info FileInfo) error {
+	zr, err := zip.OpenReader(info.Container)
+	if err != nil {
+		return err
+	}
+	f.closers = append(f.closers, zr)
+	for _, fe := range zr.File {
+		if memberName(fe.Name) == memberName(info.Path) {
+			f.file, err = fe.Open()
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileContainer) openTarMember(info FileInfo) error {
+	tf, err := os.Open(info.Container)
+	if err != nil {
+		return err
+	}
+	f.file = tf
+	err = f.decompress(info.Container)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(f.file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: reading %s", err, info.Container)
+		}
+		if hdr.Typeflag == tar.TypeReg && memberName(hdr.Name) == memberName(info.Path) {
+			f.closers = append(f.closers, f.file)
+			f.file = ioutil.NopCloser(tr)
+			return nil
+		}
+	}
+	f.closers = append(f.closers, f.file)
+	f.file = nil
+	return nil
+}
+
+func matchSegments(patt []string, name []string) bool {
+	if len(patt) == 0 {
+		return len(name) == 0
+	}
+	if patt[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(patt[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(patt[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(patt[1:], name[1:])
+}
+
+// match a slash separated name against a glob pattern,
+// "**" matches any number of directories
+func matchGlob(patt string, name string) bool {
+	return matchSegments(strings.Split(memberName(patt), "/"), strings.Split(memberName(name), "/"))
+}
+
+func hasGlob(patt string) bool {
+	return strings.ContainsAny(patt, "*?[")
+}
+
+// names of the files in a zip or tar container
+func listMembers(container string) ([]string, error) {
+	var names []string
+	if !isTar(container) {
+		zr, err := zip.OpenReader(container)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, fe := range zr.File {
+			if !fe.FileInfo().IsDir() {
+				names = append(names, fe.Name)
+			}
+		}
+		return names, nil
+	}
+	f := &FileContainer{}
+	defer f.Close()
+	var err error
+	f.file, err = os.Open(container)
+	if err != nil {
+		return nil, err
+	}
+	err = f.decompress(container)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(f.file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading %s", err, container)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			names = append(names, hdr.Name)
+		}
+	}
+	return names, nil
+}
+
+// open a file, or a member of a zip or tar container,
+// compressed files are decompressed on the fly
+func (m *Musql) opencontainer(info FileInfo) (f *FileContainer, err error) {
+	f = &FileContainer{}
+	if info.Command {
+		b, err := m.sourceData("command\n"+info.Dir+"\n"+info.Path, func() ([]byte, error) {
+			return commandOutput(info)
+		})
+		if err != nil {
+			return nil, err
+		}
+		f.file = ioutil.NopCloser(bytes.NewReader(b))
+	} else if info.Container == "" && isURL(info.Path) {
+		b, err := m.sourceData("url\n"+info.Path+"\n"+strings.Join(info.HTTP.Headers, "\n"), func() ([]byte, error) {
+			return m.fetchURL(info)
+		})
+		if err != nil {
+			return nil, err
+		}
+		f.file = ioutil.NopCloser(bytes.NewReader(b))
+	} else if info.Container == "" && info.Path == stdinName {
+		b, err := m.sourceData(stdinName, readStdin)
+		if err != nil {
+			return nil, err
+		}
+		f.file = ioutil.NopCloser(bytes.NewReader(b))
+	} else if info.Container == "" {
+		f.file, err = os.Open(info.Path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if isTar(info.Container) {
+			err = f.openTarMember(info)
+		} else {
+			err = f.openZipMember(info)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if f.file == nil {
+			// file not found
+			f.Close()
+			return nil, fmt.Errorf("could not find %s in %s", info.Path, info.Container)
+		}
+	}
+	if !info.Command {
+		// the output of a command has no file name
+		err = f.decompress(info.Path)
+	}
+	if err == nil {
+		err = f.decode(info.Path, info.Encoding)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileContainer) Close() {
+	if f.file != nil {
+		f.file.Close()
+	}
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		f.closers[i].Close()
+	}
+}
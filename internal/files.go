@@ -0,0 +1,319 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// FileOptions control which files of a directory are stored and how
+type FileOptions struct {
+	// store the content of the files
+	Content bool
+	// store the sha256 of the content
+	Hash bool
+	// glob patterns for the files to store (all files if empty)
+	Include []string
+	// glob patterns for files and directories to leave out
+	Exclude []string
+	// maximal depth below the root, 0 for no limit
+	MaxDepth int
+	// no content for larger files, 0 for no limit
+	MaxSize int64
+}
+
+var filesHeader = []string{"fullpath", "filename", "content", "relpath", "dir", "ext", "depth", "size", "mtime", "mode", "linktarget", "sha256", "mime", "encoding"}
+var filesTypes = []string{typeText, typeText, typeBlob, typeText, typeText, typeText, typeInteger, typeInteger, typeDatetime, typeText, typeText, typeText, typeText, typeText}
+
+// parse a size in bytes with an optional k, m or g suffix
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	mult := int64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 1 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("bad size '%s'", s)
+	}
+	return n * mult, nil
+}
+
+// bytes looked at to detect the type of a file
+const sniffSize = 8192
+
+// detect the encoding of text, "binary" for other data.
+// If the data is only the start of the file, a character
+// may be cut at the end.
+func detectEncoding(b []byte, complete bool) string {
+	if bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}) {
+		return "utf-8"
+	}
+	if bytes.HasPrefix(b, []byte{0xff, 0xfe}) {
+		return "utf-16le"
+	}
+	if bytes.HasPrefix(b, []byte{0xfe, 0xff}) {
+		return "utf-16be"
+	}
+	if bytes.IndexByte(b, 0) >= 0 {
+		return "binary"
+	}
+	if !complete {
+		// ignore a cut utf-8 sequence
+		for i := 0; i < utf8.UTFMax && i < len(b); i++ {
+			if utf8.RuneStart(b[len(b)-1-i]) {
+				if !utf8.FullRune(b[len(b)-1-i:]) {
+					b = b[:len(b)-1-i]
+				}
+				break
+			}
+		}
+	}
+	if utf8.Valid(b) {
+		for _, c := range b {
+			if c >= 0x80 {
+				return "utf-8"
+			}
+		}
+		return "ascii"
+	}
+	// 8 bit text has (almost) no control characters
+	ctrl := 0
+	for _, c := range b {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' {
+			ctrl++
+		}
+	}
+	if ctrl*100 <= len(b) {
+		return "iso-8859-1"
+	}
+	return "binary"
+}
+
+// the text of the file as utf-8, nil for binary files
+func decodeText(b []byte, encoding string) interface{} {
+	switch encoding {
+	case "ascii":
+		return string(b)
+	case "utf-8":
+		return string(bytes.TrimPrefix(b, []byte{0xef, 0xbb, 0xbf}))
+	case "utf-16le", "utf-16be":
+		u := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			if encoding == "utf-16le" {
+				u = append(u, uint16(b[i])|uint16(b[i+1])<<8)
+			} else {
+				u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+			}
+		}
+		return string(utf16.Decode(u))
+	case "iso-8859-1":
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r)
+	}
+	return nil
+}
+
+// detect the mime type by content, the extension helps for generic types
+func detectMime(head []byte, name string) string {
+	mt := http.DetectContentType(head)
+	if mt == "application/octet-stream" || strings.HasPrefix(mt, "text/plain") {
+		if byext := mime.TypeByExtension(path.Ext(name)); byext != "" {
+			mt = byext
+		}
+	}
+	if i := strings.Index(mt, ";"); i >= 0 {
+		mt = mt[:i]
+	}
+	return mt
+}
+
+type fileContent struct {
+	content  interface{}
+	hash     interface{}
+	mime     interface{}
+	encoding interface{}
+}
+
+// read the start of the file for the type, and the complete file
+// for the content (text or binary) and the hash. Unreadable files
+// only fail if the content or the hash is requested.
+func readFileContent(fullpath string, size int64, fopts FileOptions) (fileContent, error) {
+	var fc fileContent
+	f, err := os.Open(fullpath)
+	if err != nil {
+		if !fopts.Content && !fopts.Hash {
+			return fc, nil
+		}
+		return fc, err
+	}
+	defer f.Close()
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		if !fopts.Content && !fopts.Hash {
+			return fc, nil
+		}
+		return fc, err
+	}
+	head = head[:n]
+	complete := int64(n) == size
+
+	withcontent := fopts.Content && (fopts.MaxSize <= 0 || size <= fopts.MaxSize)
+	data := head
+	if !complete && withcontent {
+		rest, err := ioutil.ReadAll(f)
+		if err != nil {
+			return fc, err
+		}
+		data = append(head, rest...)
+		complete = true
+	}
+	if fopts.Hash {
+		// without the content the rest of the file is only streamed
+		h := sha256.New()
+		h.Write(data)
+		if !complete {
+			_, err = io.Copy(h, f)
+			if err != nil {
+				return fc, err
+			}
+		}
+		fc.hash = hex.EncodeToString(h.Sum(nil))
+	}
+	encoding := detectEncoding(data, complete)
+	fc.encoding = encoding
+	fc.mime = detectMime(head, fullpath)
+	if withcontent {
+		if text := decodeText(data, encoding); text != nil {
+			fc.content = text
+		} else {
+			fc.content = data
+		}
+	}
+	return fc, nil
+}
+
+// patterns without a '/' match the file name, the others the relative path
+func matchFilePattern(patterns []string, relpath string) bool {
+	for _, p := range patterns {
+		if strings.Contains(p, "/") {
+			if matchGlob(p, relpath) {
+				return true
+			}
+		} else if ok, _ := path.Match(p, path.Base(relpath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Musql) AddFiles(tablename string, root string, fopts FileOptions, opts ImportOptions) error {
+	err := prepareTable(m.db, tablename, filesHeader, filesTypes, opts)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	insert, err := makeInsert(tx, tablename, filesHeader, opts.upsertKeys())
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(root, func(fullpath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, fullpath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		depth := strings.Count(rel, "/") + 1
+		if f.IsDir() {
+			if matchFilePattern(fopts.Exclude, rel) || (fopts.MaxDepth > 0 && depth >= fopts.MaxDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fopts.MaxDepth > 0 && depth > fopts.MaxDepth {
+			return nil
+		}
+		if matchFilePattern(fopts.Exclude, rel) {
+			return nil
+		}
+		if len(fopts.Include) > 0 && !matchFilePattern(fopts.Include, rel) {
+			return nil
+		}
+
+		readable := f.Mode().IsRegular()
+		size := f.Size()
+		if f.Mode()&os.ModeSymlink != 0 {
+			// the content of links to files (the size of the target)
+			stat, err := os.Stat(fullpath)
+			readable = err == nil && stat.Mode().IsRegular()
+			if readable {
+				size = stat.Size()
+			}
+		}
+		var fc fileContent
+		if readable {
+			fc, err = readFileContent(fullpath, size, fopts)
+			if err != nil {
+				return err
+			}
+		}
+		var linktarget interface{}
+		if f.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(fullpath)
+			if err != nil {
+				return err
+			}
+			linktarget = target
+		}
+		fn := path.Base(fullpath)
+		_, err = insert.Exec(fullpath, fn, fc.content, rel, path.Dir(rel), path.Ext(fn), depth,
+			f.Size(), f.ModTime().Format("2006-01-02 15:04:05"), f.Mode().String(), linktarget, fc.hash, fc.mime, fc.encoding)
+		if err != nil {
+			return fmt.Errorf("%w: storing file info for %s", err, fn)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// timeout of url requests without an explicit timeout
+const defaultTimeout = 30 * time.Second
+
+// HTTPOptions control how url sources are fetched
+type HTTPOptions struct {
+	// request headers, "<name>: <value>"
+	Headers []string
+	// 0 for the default timeout
+	Timeout time.Duration
+	// directory for the cached responses, empty for no cache
+	CacheDir string
+}
+
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// the cache files for the body and the etag of the url, the request
+// headers (tokens) are part of the key
+func urlCacheFiles(u string, opts HTTPOptions) (string, string, error) {
+	err := os.MkdirAll(opts.CacheDir, 0700)
+	if err != nil {
+		return "", "", err
+	}
+	key := u + "\n" + strings.Join(opts.Headers, "\n")
+	name := filepath.Join(opts.CacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(key))))
+	return name + ".body", name + ".etag", nil
+}
+
+// certificate errors are not hidden by a cached response
+func isCertificateError(err error) bool {
+	var authority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &authority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
+
+// fetch the url. With a cache directory, the cached body is used if
+// the server reports no change (same etag) or cannot be reached.
+func (m *Musql) fetchURL(info FileInfo) ([]byte, error) {
+	u := info.Path
+	var bodyfile, etagfile string
+	var cached, etag []byte
+	if info.HTTP.CacheDir != "" {
+		var err error
+		bodyfile, etagfile, err = urlCacheFiles(u, info.HTTP)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cache for %s", err, u)
+		}
+		cached, _ = ioutil.ReadFile(bodyfile)
+		etag, _ = ioutil.ReadFile(etagfile)
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range info.HTTP.Headers {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad header '%s' for %s", h, u)
+		}
+		req.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if cached != nil && len(etag) > 0 {
+		req.Header.Set("If-None-Match", string(etag))
+	}
+	timeout := info.HTTP.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		if cached != nil && !isCertificateError(err) {
+			// offline: the last response has to do
+			m.logf("%s: %v, using the cached response", u, err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		m.logf("%s: not modified, using the cached response", u)
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: fetching %s", err, u)
+	}
+	if bodyfile == "" {
+		return b, nil
+	}
+	// the responses may need the credentials of the headers
+	err = ioutil.WriteFile(bodyfile, b, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(etagfile, []byte(resp.Header.Get("ETag")), 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: cache for %s", err, u)
+	}
+	return b, nil
+}
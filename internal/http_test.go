@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("a;b\n1;2\n3;4\n"))
+	}))
+	cache := t.TempDir()
+	run := func() error {
+		var c = &Config{}
+		err := c.Parse([]string{"insert", srv.URL + "/export.csv", "into", "t", "header", "X-Token: secret", "timeout", "5", "cache", cache})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var m = &Musql{}
+		defer m.Close()
+		err = c.Apply(m)
+		if err != nil {
+			return err
+		}
+		got := strings.Join(queryStrings(t, m, "select sum(a) || ':' || sum(b) from t"), ",")
+		if got != "4:6" {
+			t.Errorf("bad sums: %s", got)
+		}
+		return nil
+	}
+	if err := run(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := run(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expecting one request per run, got %d", requests)
+	}
+	files, _ := filepath.Glob(filepath.Join(cache, "*"))
+	for _, f := range files {
+		if st, err := os.Stat(f); err != nil || st.Mode().Perm() != 0600 {
+			t.Errorf("cache file %s is not private", f)
+		}
+	}
+	srv.Close()
+	if err := run(); err != nil {
+		t.Errorf("cached response not used: %v", err)
+	}
+
+	// the cached response of another token is not used
+	var c = &Config{}
+	err := c.Parse([]string{"insert", srv.URL + "/export.csv", "into", "t", "header", "X-Token: other", "cache", cache})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	if err = c.Apply(m); err == nil {
+		t.Errorf("expecting error for unreachable url with another token")
+	}
+
+	// certificate errors are not hidden by the cache
+	tlssrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a;b\n5;6\n"))
+	}))
+	defer tlssrv.Close()
+	hopts := HTTPOptions{CacheDir: cache}
+	bodyfile, _, err := urlCacheFiles(tlssrv.URL, hopts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	writeTestFile(t, filepath.Dir(bodyfile), filepath.Base(bodyfile), "a;b\n1;2\n")
+	var log bytes.Buffer
+	m.verbose = &log
+	_, err = m.fetchURL(FileInfo{Path: tlssrv.URL, HTTP: hopts})
+	if err == nil || !isCertificateError(err) {
+		t.Errorf("expecting certificate error, got %v", err)
+	}
+
+	// the fallback to the cache is reported
+	_, err = m.fetchURL(FileInfo{Path: srv.URL + "/export.csv", HTTP: HTTPOptions{Headers: []string{"X-Token: secret"}, CacheDir: cache}})
+	if err != nil || !strings.Contains(log.String(), "using the cached response") {
+		t.Errorf("fallback to the cache not reported: %v %s", err, log.String())
+	}
+
+	c = &Config{}
+	err = c.Parse([]string{"insert", srv.URL + "/other.csv", "into", "t", "cache", cache})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err = c.Apply(m); err == nil {
+		t.Errorf("expecting error for unreachable url")
+	}
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// table with the state of the imported files, the leading '_'
+// keeps it out of the template data
+const sourcesTable = "_musql_sources"
+
+// state of a file on disk (for members of containers: of the container)
+type sourceState struct {
+	path  string
+	size  int64
+	mtime string
+	hash  string
+}
+
+func hashFile(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// the files read for the import of a table
+func sourceFiles(path []FileInfo) ([]string, error) {
+	files, err := globFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	var fnames []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		fname := f.Path
+		if f.Container != "" {
+			fname = f.Container
+		}
+		if !seen[fname] {
+			seen[fname] = true
+			fnames = append(fnames, fname)
+		}
+	}
+	return fnames, nil
+}
+
+func (m *Musql) ensureSourcesTable() error {
+	_, err := m.db.Exec(fmt.Sprintf(`create table if not exists "%s" (tablename TEXT, signature TEXT, path TEXT, size INTEGER, mtime TEXT, hash TEXT)`, sourcesTable))
+	if err != nil {
+		return fmt.Errorf("%w: creating %s", err, sourcesTable)
+	}
+	return nil
+}
+
+// check if the files of a table are the same as in the last import.
+// signature describes the import (options, file list), a different
+// signature always needs a new import. The returned states are
+// stored with recordSources after the import.
+func (m *Musql) sourcesUnchanged(tablename string, path []FileInfo, signature string) (bool, []sourceState, error) {
+	err := m.ensureSourcesTable()
+	if err != nil {
+		return false, nil, err
+	}
+	for _, f := range path {
+		if f.Command || isURL(f.Path) {
+			// the output of commands and urls is not known before reading them
+			return false, nil, nil
+		}
+	}
+	fnames, err := sourceFiles(path)
+	if err != nil {
+		// let the import report the missing files
+		return false, nil, nil
+	}
+	last := make(map[string]sourceState)
+	rows, err := m.db.Query(fmt.Sprintf(`select path, size, mtime, hash from "%s" where tablename = ? and signature = ?`, sourcesTable), tablename, signature)
+	if err != nil {
+		return false, nil, err
+	}
+	for rows.Next() {
+		var s sourceState
+		err = rows.Scan(&s.path, &s.size, &s.mtime, &s.hash)
+		if err != nil {
+			rows.Close()
+			return false, nil, err
+		}
+		last[s.path] = s
+	}
+	rows.Close()
+
+	unchanged := len(last) == len(fnames)
+	var states []sourceState
+	for _, fname := range fnames {
+		stat, err := os.Stat(fname)
+		if err != nil || stat.IsDir() {
+			// directories (and stdin) are always read again
+			return false, nil, nil
+		}
+		s := sourceState{path: fname, size: stat.Size(), mtime: stat.ModTime().UTC().Format(time.RFC3339Nano)}
+		prev, ok := last[fname]
+		if ok && prev.size == s.size && prev.mtime == s.mtime {
+			// size and time unchanged: no need to read the file
+			s.hash = prev.hash
+		} else {
+			s.hash, err = hashFile(fname)
+			if err != nil {
+				return false, nil, err
+			}
+			if !ok || prev.hash != s.hash {
+				unchanged = false
+			}
+		}
+		states = append(states, s)
+	}
+	if unchanged {
+		columns, err := tableColumns(m.db, tablename)
+		if err != nil {
+			return false, nil, err
+		}
+		unchanged = columns != nil
+	}
+	return unchanged, states, nil
+}
+
+// store the state of the imported files of an insert into a table.
+// Several inserts into one table have their own records, unless the
+// import replaced the rows of the others.
+func (m *Musql) recordSources(tablename string, signature string, states []sourceState, replaced bool) error {
+	err := m.ensureSourcesTable()
+	if err != nil {
+		return err
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	if replaced {
+		_, err = tx.Exec(fmt.Sprintf(`delete from "%s" where tablename = ?`, sourcesTable), tablename)
+	} else {
+		_, err = tx.Exec(fmt.Sprintf(`delete from "%s" where tablename = ? and signature = ?`, sourcesTable), tablename, signature)
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		_, err = tx.Exec(fmt.Sprintf(`insert into "%s" values (?, ?, ?, ?, ?, ?)`, sourcesTable), tablename, signature, s.path, s.size, s.mtime, s.hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
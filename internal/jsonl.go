@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/antchfx/jsonquery"
+	"github.com/antchfx/xpath"
+	"io"
+	"sort"
+	"strings"
+)
+
+// json lines: one json document per line, each selected node
+// of a line is a row
+type jsonlRows struct {
+	f       *FileContainer
+	r       *bufio.Reader
+	info    FileInfo
+	expr    *xpath.Expr
+	sels    []compiledSelect
+	header  []string
+	line    int
+	pending []map[string]string
+}
+
+func (m *Musql) newJsonlRows(info FileInfo, expr *xpath.Expr, sels []compiledSelect) (*jsonlRows, error) {
+	f, err := m.opencontainer(info)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlRows{f: f, r: bufio.NewReader(f.file), info: info, expr: expr, sels: sels}, nil
+}
+
+// read the next line with content and flatten the selected nodes
+func (j *jsonlRows) next() ([]map[string]string, error) {
+	for {
+		line, err := j.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		j.line++
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		doc, perr := jsonquery.Parse(strings.NewReader(line))
+		if perr != nil {
+			return nil, fmt.Errorf("%w: line %d of %s", perr, j.line, j.info.Path)
+		}
+		var rows []map[string]string
+		rt := j.expr.Select(jsonquery.CreateXPathNavigator(doc))
+		for rt.MoveNext() {
+			_, d, ferr := flattenSelects(rt.Current(), j.sels, &flattenOptions{})
+			if ferr != nil {
+				return nil, fmt.Errorf("%w: line %d of %s", ferr, j.line, j.info.Path)
+			}
+			rows = append(rows, d)
+		}
+		return rows, nil
+	}
+}
+
+// the union of the keys of all lines of a json lines file
+func (m *Musql) jsonlHeader(info FileInfo, expr *xpath.Expr, sels []compiledSelect) ([]string, error) {
+	scan, err := m.newJsonlRows(info, expr, sels)
+	if err != nil {
+		return nil, err
+	}
+	defer scan.Close()
+	keys := make(map[string]bool)
+	for {
+		rows, err := scan.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range rows {
+			for k := range d {
+				keys[k] = true
+			}
+		}
+	}
+	var header []string
+	for k := range keys {
+		header = append(header, k)
+	}
+	sort.Strings(header)
+	if len(header) == 0 {
+		return nil, fmt.Errorf("no values found in %s", info.Path)
+	}
+	return header, nil
+}
+
+// open a json lines file, the header is the union of the keys of all
+// lines: without a header from a previous open, this needs an extra
+// pass over the file
+func (m *Musql) openJsonl(info FileInfo, xpathstr string, xselects []Select, header []string) (*jsonlRows, error) {
+	if xpathstr == "" {
+		xpathstr = "."
+	}
+	expr, err := xpath.Compile(xpathstr)
+	if err != nil {
+		return nil, err
+	}
+	sels, err := compileSelects(xselects)
+	if err != nil {
+		return nil, err
+	}
+
+	if header == nil {
+		header, err = m.jsonlHeader(info, expr, sels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	j, err := m.newJsonlRows(info, expr, sels)
+	if err != nil {
+		return nil, err
+	}
+	j.header = header
+	return j, nil
+}
+
+func (j *jsonlRows) Header() []string {
+	return j.header
+}
+
+func (j *jsonlRows) Read() ([]string, error) {
+	for len(j.pending) == 0 {
+		rows, err := j.next()
+		if err != nil {
+			return nil, err
+		}
+		j.pending = rows
+	}
+	d := j.pending[0]
+	j.pending = j.pending[1:]
+	row := make([]string, len(j.header))
+	for i, h := range j.header {
+		row[i] = d[h]
+	}
+	return row, nil
+}
+
+func (j *jsonlRows) lineNumber() int {
+	return j.line
+}
+
+func (j *jsonlRows) Close() {
+	j.f.Close()
+}
+
+func (m *Musql) AddJsonl(tablename string, path []FileInfo, xpathstr string, xselects []Select, opts ImportOptions) error {
+	// the files are opened more than once (types, then the rows)
+	headers := make(map[string][]string)
+	open := func(info FileInfo) (rowReader, error) {
+		key := info.Container + "\n" + info.Path
+		j, err := m.openJsonl(info, xpathstr, xselects, headers[key])
+		if err == nil {
+			headers[key] = j.header
+		}
+		return j, err
+	}
+	return m.addRowFiles(tablename, path, open, opts)
+}
@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// column with the line number of the row
+const lineColumn = "line"
+
+// LineOptions control how text files are read line by line
+type LineOptions struct {
+	// add non matching lines to this column of the previous row
+	Continuation string
+	// add the line number as first column
+	LineNumbers bool
+}
+
+// reads a text file line by line
+type lineReader struct {
+	f    *FileContainer
+	r    *bufio.Reader
+	line int
+}
+
+func (m *Musql) openLines(info FileInfo) (*lineReader, error) {
+	f, err := m.opencontainer(info)
+	if err != nil {
+		return nil, err
+	}
+	return &lineReader{f: f, r: bufio.NewReader(f.file)}, nil
+}
+
+// the next line without the line end, io.EOF at the end of the file
+func (l *lineReader) next() (string, error) {
+	s, err := l.r.ReadString('\n')
+	if err != nil && (err != io.EOF || s == "") {
+		return "", err
+	}
+	l.line++
+	s = strings.TrimSuffix(s, "\n")
+	s = strings.TrimSuffix(s, "\r")
+	return s, nil
+}
+
+func (l *lineReader) lineNumber() int {
+	return l.line
+}
+
+func (l *lineReader) Close() {
+	l.f.Close()
+}
+
+// rows from the named groups of a regular expression
+type regexRows struct {
+	*lineReader
+	re      *regexp.Regexp
+	opts    LineOptions
+	header  []string
+	groups  []int
+	cont    int
+	pending []string
+}
+
+// the columns are the named groups of the expression
+func regexHeader(re *regexp.Regexp, opts LineOptions) ([]string, []int, int, error) {
+	var header []string
+	var groups []int
+	cont := -1
+	if opts.LineNumbers {
+		header = append(header, lineColumn)
+	}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if opts.LineNumbers && name == lineColumn {
+			return nil, nil, 0, fmt.Errorf("group name '%s' is used for the line numbers", lineColumn)
+		}
+		if name == opts.Continuation {
+			cont = len(header)
+		}
+		header = append(header, name)
+		groups = append(groups, i)
+	}
+	if len(groups) == 0 {
+		return nil, nil, 0, fmt.Errorf("no named groups in '%s'", re.String())
+	}
+	if opts.Continuation != "" && cont < 0 {
+		return nil, nil, 0, fmt.Errorf("no group '%s' for continuation lines in '%s'", opts.Continuation, re.String())
+	}
+	return header, groups, cont, nil
+}
+
+func (m *Musql) openRegex(info FileInfo, re *regexp.Regexp, opts LineOptions) (*regexRows, error) {
+	header, groups, cont, err := regexHeader(re, opts)
+	if err != nil {
+		return nil, err
+	}
+	l, err := m.openLines(info)
+	if err != nil {
+		return nil, err
+	}
+	return &regexRows{lineReader: l, re: re, opts: opts, header: header, groups: groups, cont: cont}, nil
+}
+
+func (r *regexRows) Header() []string {
+	return r.header
+}
+
+func (r *regexRows) Read() ([]string, error) {
+	for {
+		line, err := r.next()
+		if err == io.EOF && r.pending != nil {
+			row := r.pending
+			r.pending = nil
+			return row, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := r.re.FindStringSubmatch(line)
+		if m == nil {
+			if r.cont >= 0 && r.pending != nil {
+				r.pending[r.cont] += "\n" + line
+			}
+			continue
+		}
+		var row []string
+		if r.opts.LineNumbers {
+			row = append(row, strconv.Itoa(r.line))
+		}
+		for _, g := range r.groups {
+			row = append(row, m[g])
+		}
+		if r.cont < 0 {
+			return row, nil
+		}
+		// the row is complete with the next matching line
+		prev := r.pending
+		r.pending = row
+		if prev != nil {
+			return prev, nil
+		}
+	}
+}
+
+func (m *Musql) AddRegex(tablename string, path []FileInfo, expr string, lopts LineOptions, opts ImportOptions) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	open := func(info FileInfo) (rowReader, error) {
+		return m.openRegex(info, re, lopts)
+	}
+	return m.addRowFiles(tablename, path, open, opts)
+}
+
+// a field of a fixed width record, 1-based positions (characters, not bytes)
+type FixedField struct {
+	Name  string
+	Start int
+	End   int
+}
+
+var reFixedField = regexp.MustCompile(`^([^:]+)(:[A-Za-z]+)?:([0-9]+)-([0-9]+)$`)
+
+// parse a field declaration: <name>[:<type>]:<start>-<end>
+func ParseFixedField(spec string) (FixedField, Column, error) {
+	m := reFixedField.FindStringSubmatch(spec)
+	if m == nil {
+		return FixedField{}, Column{}, fmt.Errorf("bad field declaration '%s'", spec)
+	}
+	f := FixedField{Name: m[1]}
+	f.Start, _ = strconv.Atoi(m[3])
+	f.End, _ = strconv.Atoi(m[4])
+	if f.Start < 1 || f.End < f.Start {
+		return FixedField{}, Column{}, fmt.Errorf("bad positions in field declaration '%s'", spec)
+	}
+	c := Column{Name: f.Name}
+	if m[2] != "" {
+		t, err := columnType(m[2][1:])
+		if err != nil {
+			return FixedField{}, Column{}, fmt.Errorf("%w in '%s'", err, spec)
+		}
+		c.Type = t
+	}
+	return f, c, nil
+}
+
+// IsFixedField checks if the word looks like a field declaration
+func IsFixedField(spec string) bool {
+	return reFixedField.MatchString(spec)
+}
+
+// rows sliced from fixed width records
+type fixedRows struct {
+	*lineReader
+	fields []FixedField
+	header []string
+}
+
+func (m *Musql) openFixed(info FileInfo, fields []FixedField) (*fixedRows, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields declared for %s", info.Path)
+	}
+	l, err := m.openLines(info)
+	if err != nil {
+		return nil, err
+	}
+	r := &fixedRows{lineReader: l, fields: fields}
+	for _, f := range fields {
+		r.header = append(r.header, f.Name)
+	}
+	return r, nil
+}
+
+func (r *fixedRows) Header() []string {
+	return r.header
+}
+
+func (r *fixedRows) Read() ([]string, error) {
+	for {
+		line, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		chars := []rune(line)
+		row := make([]string, len(r.fields))
+		for i, f := range r.fields {
+			start := f.Start - 1
+			end := f.End
+			if start > len(chars) {
+				start = len(chars)
+			}
+			if end > len(chars) {
+				end = len(chars)
+			}
+			row[i] = strings.TrimSpace(string(chars[start:end]))
+		}
+		return row, nil
+	}
+}
+
+func (m *Musql) AddFixed(tablename string, path []FileInfo, fields []FixedField, opts ImportOptions) error {
+	open := func(info FileInfo) (rowReader, error) {
+		return m.openFixed(info, fields)
+	}
+	return m.addRowFiles(tablename, path, open, opts)
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Locale describes how numbers and dates are written in a source.
+// The values are stored the sqlite way: "1234.56" and "2021-12-31".
+type Locale struct {
+	Name string
+	// grouping and decimal separator of numbers
+	Thousands rune
+	Decimal   rune
+	// go layouts of dates and of dates with time
+	Dates     []string
+	Datetimes []string
+	number    *regexp.Regexp
+}
+
+func newLocale(name string, thousands rune, decimal rune, dates []string, datetimes []string) Locale {
+	t := regexp.QuoteMeta(string(thousands))
+	if thousands == ' ' {
+		// also the no-break spaces used for grouping
+		t = "[ \u00a0\u202f]"
+	}
+	d := regexp.QuoteMeta(string(decimal))
+	// grouped or plain digits, no leading zeros (as in types.go)
+	expr := fmt.Sprintf(`^[-+]?(0|[1-9][0-9]{0,2}(%s[0-9]{3})+|[1-9][0-9]*)(%s[0-9]+)?$`, t, d)
+	return Locale{Name: name, Thousands: thousands, Decimal: decimal, Dates: dates, Datetimes: datetimes,
+		number: regexp.MustCompile(expr)}
+}
+
+var dotDates = []string{"02.01.2006", "2.1.2006"}
+var dotDatetimes = []string{"02.01.2006 15:04:05", "02.01.2006 15:04", "2.1.2006 15:04:05", "2.1.2006 15:04"}
+var slashDates = []string{"02/01/2006", "2/1/2006"}
+var slashDatetimes = []string{"02/01/2006 15:04:05", "02/01/2006 15:04", "2/1/2006 15:04:05", "2/1/2006 15:04"}
+var usDates = []string{"01/02/2006", "1/2/2006"}
+var usDatetimes = []string{"01/02/2006 15:04:05", "01/02/2006 15:04", "1/2/2006 15:04:05", "1/2/2006 15:04", "1/2/2006 3:04 PM"}
+
+var locales = map[string]Locale{
+	"de": newLocale("de", '.', ',', dotDates, dotDatetimes),
+	"ch": newLocale("ch", '\'', '.', dotDates, dotDatetimes),
+	"fr": newLocale("fr", ' ', ',', slashDates, slashDatetimes),
+	"uk": newLocale("uk", ',', '.', slashDates, slashDatetimes),
+	"us": newLocale("us", ',', '.', usDates, usDatetimes),
+}
+
+// aliases of the locale names
+var localeNames = map[string]string{
+	"de_de": "de", "de_at": "de", "at": "de",
+	"de_ch": "ch",
+	"fr_fr": "fr",
+	"en_gb": "uk", "gb": "uk",
+	"en": "us", "en_us": "us",
+}
+
+// ParseLocale looks up a locale by name (de, ch, fr, uk, us or de_DE etc.)
+func ParseLocale(name string) (Locale, error) {
+	key := strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+	if alias, ok := localeNames[key]; ok {
+		key = alias
+	}
+	l, ok := locales[key]
+	if !ok {
+		return Locale{}, fmt.Errorf("unknown locale '%s'", name)
+	}
+	return l, nil
+}
+
+func parseLayouts(layouts []string, s string) (time.Time, bool) {
+	for _, l := range layouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// write a number or date of the locale the sqlite way. Other values
+// are returned unchanged, ok is false if they are no number or date
+// in the sqlite format either.
+func (l *Locale) parse(s string) (string, bool) {
+	if l.number.MatchString(s) {
+		s = strings.Map(func(r rune) rune {
+			switch {
+			case r == l.Decimal:
+				return '.'
+			case r == l.Thousands || (l.Thousands == ' ' && (r == '\u00a0' || r == '\u202f')):
+				return -1
+			}
+			return r
+		}, s)
+		return strings.TrimPrefix(s, "+"), true
+	}
+	if t, ok := parseLayouts(l.Dates, s); ok {
+		return t.Format("2006-01-02"), true
+	}
+	if t, ok := parseLayouts(l.Datetimes, s); ok {
+		return t.Format("2006-01-02 15:04:05"), true
+	}
+	return s, isReal(s) || isDatetime(s)
+}
+
+// check that a normalized value fits the column type
+func fitsType(coltype string, s string) bool {
+	switch coltype {
+	case typeInteger, typeReal:
+		return isReal(s)
+	case typeDate:
+		return isDate(s)
+	case typeDatetime:
+		return isDatetime(s)
+	}
+	return true
+}
@@ -1,80 +1,113 @@
 package internal
 
 import (
-	"archive/zip"
+	"bufio"
 	"database/sql"
 	"encoding/csv"
 	"fmt"
-	"github.com/antchfx/jsonquery"
-	"github.com/antchfx/xmlquery"
 	"github.com/antchfx/xpath"
 	"github.com/frohmut/mustache"
 	_ "github.com/mattn/go-sqlite3"
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 type Musql struct {
 	db *sql.DB
+	// messages about the import, nil for none
+	verbose io.Writer
+	// data of the standard input, of commands and of urls: sources are
+	// read more than once (header and types, then the rows), but the
+	// standard input can only be read once and commands and urls
+	// should only run once
+	sources map[string][]byte
+}
+
+// the data of the source with the key, read on first use
+func (m *Musql) sourceData(key string, read func() ([]byte, error)) ([]byte, error) {
+	if b, ok := m.sources[key]; ok {
+		return b, nil
+	}
+	b, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if m.sources == nil {
+		m.sources = make(map[string][]byte)
+	}
+	m.sources[key] = b
+	return b, nil
+}
+
+func (m *Musql) logf(format string, args ...interface{}) {
+	if m.verbose != nil {
+		fmt.Fprintf(m.verbose, format+"\n", args...)
+	}
 }
 
 type FileInfo struct {
 	Path      string
 	Container string
+	// Path is a command line, the output of the command is read
+	Command bool
+	// working directory of the command
+	Dir string
+	// request options of urls
+	HTTP HTTPOptions
+	// character encoding of the text, empty for utf-8 (or utf-16 with a byte order mark)
+	Encoding string
 }
 
 type Select struct {
 	Path string
 	Name string
+	// how repeated elements are stored (RepeatIndex, ...), empty for an error
+	Repeat string
+	// separator of the values for RepeatJoin
+	Delim string
 }
 
-type FileContainer struct {
-	container *zip.ReadCloser
-	file      io.ReadCloser
+// ImportOptions control how the imported values are stored
+type ImportOptions struct {
+	// store all values as text in untyped columns
+	Untyped bool
+	// column declarations, one per source column
+	Columns []Column
+	// the source has its own header line the column declarations
+	// apply to (otherwise the declarations replace the header line)
+	KeepHeader bool
+	// add columns with the origin of every row
+	Provenance bool
+	// map the columns of the files by name and add missing
+	// columns instead of failing on different headers
+	MergeColumns bool
+	// replace the rows of the table (default), append or upsert them
+	Mode string
+	// key columns for upsert
+	Keys []string
+	// locale of the numbers and dates in the source (see ParseLocale)
+	Locale string
+	// locales of single source columns, they override Locale
+	ColumnLocales map[string]string
 }
 
-func opencontainer(info FileInfo) (f *FileContainer, err error) {
-	f = &FileContainer{}
-	if info.Container == "" {
-		f.file, err = os.Open(info.Path)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		f.container, err = zip.OpenReader(info.Container)
-		if err != nil {
-			return nil, err
-		}
-		for _, fe := range f.container.File {
-			if fe.Name == info.Path {
-				f.file, err = fe.Open()
-				if err != nil {
-					return nil, err
-				}
-				break
-			}
-		}
-		if f.file == nil {
-			// file not found
-			return nil, fmt.Errorf("could not find %s in %s", info.Path, info.Container)
-		}
-	}
-	return f, nil
-}
+// import modes
+const (
+	ModeReplace = ""
+	ModeAppend  = "append"
+	ModeUpsert  = "upsert"
+)
 
-func (f *FileContainer) Close() {
-	if f.file != nil {
-		f.file.Close()
-	}
-	if f.container != nil {
-		f.container.Close()
+func (o ImportOptions) upsertKeys() []string {
+	if o.Mode != ModeUpsert {
+		return nil
 	}
+	return o.Keys
 }
 
 func (m *Musql) NewDb() error {
@@ -104,26 +137,36 @@ func (m *Musql) Close() {
 	}
 }
 
-func recFlattenNode(prefix string, node xpath.NodeNavigator, header map[string]int, data map[string]string, usename string) error {
+func recFlattenNode(prefix string, node xpath.NodeNavigator, header map[string]int, data map[string]string, usename string, fo *flattenOptions) error {
 	if prefix != "" {
 		prefix = prefix + "/"
 	}
 
 	nodea := node.Copy()
 	for nodea.MoveToNextAttribute() {
-		header[prefix+nodea.LocalName()] = len(header)
-		data[prefix+nodea.LocalName()] = nodea.Value()
+		header[prefix+nodeName(nodea, fo.prefixes)] = len(header)
+		data[prefix+nodeName(nodea, fo.prefixes)] = nodea.Value()
 	}
 
-	nodeData := node.LocalName()
+	nodeData := nodeName(node, fo.prefixes)
 	if usename != "" {
 		nodeData = usename
 	}
+	var groups map[string][]xpath.NodeNavigator
+	done := make(map[string]bool)
+	if fo.repeat != "" {
+		groups = childGroups(prefix, node, fo)
+	}
 	child := node.Copy()
 	for ok := child.MoveToChild(); ok; ok = child.MoveToNext() {
 		if child.NodeType() == xpath.TextNode {
 			if _, ok := header[nodeData]; ok {
-				return fmt.Errorf("duplicate entry " + nodeData + " (" + prefix + ")")
+				field := nodeData
+				if field == "" {
+					// items of a json array
+					field = strings.Trim(prefix, "/")
+				}
+				return fmt.Errorf("duplicate entry %s: use the 'repeated' option for repeated elements", field)
 			}
 			header[nodeData] = len(header)
 			data[nodeData] = child.Value()
@@ -132,7 +175,29 @@ func recFlattenNode(prefix string, node xpath.NodeNavigator, header map[string]i
 			if prefix != "" {
 				nprefix = prefix + "/" + nprefix
 			}
-			err := recFlattenNode(prefix+child.LocalName(), child, header, data, "")
+			cpath := prefix + nodeName(child, fo.prefixes)
+			if fo.repeated[cpath] {
+				fo.children[cpath] = append(fo.children[cpath], child.Copy())
+				continue
+			}
+			if g := groups[cpath]; len(g) > 1 || (len(g) > 0 && (child.LocalName() == "" || fo.repeats[cpath])) {
+				if done[cpath] {
+					// all of them are added with the first one
+					continue
+				}
+				done[cpath] = true
+				name := nodeName(child, fo.prefixes)
+				if name == "" {
+					// items of a json array
+					name = nodeData
+				}
+				err := flattenRepeated(cpath, name, g, header, data, fo)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := recFlattenNode(cpath, child, header, data, "", fo)
 			if err != nil {
 				return err
 			}
@@ -149,27 +214,109 @@ func mergemap(dest map[string]int, src map[string]int) {
 	}
 }
 
-func readTreeFile(path FileInfo, xpathstr string, xselects []Select, kind string) ([]string, []map[string]string, error) {
-	f, err := opencontainer(path)
+type compiledSelect struct {
+	Select
+	// path without the trailing '?'
+	sel  string
+	expr *xpath.Expr
+	// error if nothing is found
+	needed bool
+	// paths of the elements repeated in any row (see scanRepeats)
+	repeats map[string]bool
+}
+
+func compileSelects(xselects []Select) ([]compiledSelect, error) {
+	if len(xselects) == 0 {
+		xselects = []Select{Select{Path: "."}}
+	}
+	var sels []compiledSelect
+	for _, xsel := range xselects {
+		c := compiledSelect{Select: xsel, sel: xsel.Path, needed: true, repeats: make(map[string]bool)}
+		if strings.HasSuffix(c.sel, "?") {
+			c.sel = c.sel[:len(c.sel)-1]
+			c.needed = false
+		}
+		exp, err := xpath.Compile(c.sel)
+		if err != nil {
+			return nil, err
+		}
+		c.expr = exp
+		sels = append(sels, c)
+	}
+	return sels, nil
+}
+
+// flatten the selected parts of the node into one row
+func flattenSelects(node xpath.NodeNavigator, sels []compiledSelect, fo *flattenOptions) (map[string]int, map[string]string, error) {
+	nheader := make(map[string]int)
+	d := make(map[string]string)
+	for _, xsel := range sels {
+		sfo := *fo
+		sfo.repeat = xsel.Repeat
+		sfo.delim = xsel.Delim
+		sfo.repeats = xsel.repeats
+		t := xsel.expr.Select(node.Copy())
+
+		ok := t.MoveNext()
+		if !ok {
+			if xsel.needed {
+				nodename := node.LocalName()
+				if nodename == "" {
+					nodename = "nameless node"
+				}
+				return nil, nil, fmt.Errorf("no element found for " + xsel.sel + " in " + nodename)
+			}
+			continue
+		}
+
+		curr := t.Current().Copy()
+		nt := curr.NodeType()
+		if xsel.Repeat != "" && xsel.sel != "." {
+			// a single node is stored like several ones
+			nodes := []xpath.NodeNavigator{curr}
+			for t.MoveNext() {
+				nodes = append(nodes, t.Current().Copy())
+			}
+			err := flattenSelected(nodes, xsel.Name, nheader, d, &sfo)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if nt == xpath.AttributeNode {
+			h := nodeName(curr, fo.prefixes)
+			if xsel.Name != "" {
+				h = xsel.Name
+			}
+			nheader[h] = len(nheader)
+			d[h] = curr.Value()
+		} else {
+			pref := ""
+			err := recFlattenNode(pref, curr, nheader, d, xsel.Name, &sfo)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		more_than_one := t.MoveNext()
+		if more_than_one {
+			return nil, nil, fmt.Errorf("more than one element found for " + xsel.sel + " in " + node.LocalName())
+		}
+	}
+	return nheader, d, nil
+}
+
+func (m *Musql) readTreeFile(path FileInfo, xpathstr string, xselects []Select, kind string, topts TreeOptions) ([]string, []map[string]string, error) {
+	f, err := m.opencontainer(path)
 	if err != nil {
 		err = fmt.Errorf("%w reading header of %s", err, path.Path)
 		return nil, nil, err
 	}
 	defer f.Close()
 
-	var docnode xpath.NodeNavigator
-	if kind == "xml" {
-		doc, err := xmlquery.Parse(f.file)
-		if err != nil {
-			return nil, nil, err
-		}
-		docnode = xmlquery.CreateXPathNavigator(doc)
-	} else {
-		doc, err := jsonquery.Parse(f.file)
-		if err != nil {
-			return nil, nil, err
-		}
-		docnode = jsonquery.CreateXPathNavigator(doc)
+	docnode, err := parseTree(f.file, kind, topts)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	mheader := make(map[string]int)
@@ -179,64 +326,20 @@ func readTreeFile(path FileInfo, xpathstr string, xselects []Select, kind string
 	if err != nil {
 		return nil, nil, err
 	}
+	sels, err := compileSelects(xselects)
+	if err != nil {
+		return nil, nil, err
+	}
 	rt := rexp.Select(docnode)
 	for rt.MoveNext() {
-		node := rt.Current()
-		nheader := make(map[string]int)
-		d := make(map[string]string)
-
-		if len(xselects) == 0 {
-			s := Select{Path: "."}
-			xselects = append(xselects, s)
-		}
-		for _, xsel := range xselects {
-			err_if_mis := true
-			sel := xsel.Path
-			if strings.HasSuffix(sel, "?") {
-				sel = sel[:len(sel)-1]
-				err_if_mis = false
-			}
-			exp, err := xpath.Compile(sel)
-			if err != nil {
-				return nil, nil, err
-			}
-			t := exp.Select(node.Copy())
-
-			ok := t.MoveNext()
-			if !ok {
-				if err_if_mis {
-					nodename := node.LocalName()
-					if nodename == "" {
-						nodename = "nameless node"
-					}
-					return nil, nil, fmt.Errorf("no element found for " + sel + " in " + nodename)
-				}
-				continue
-			}
-
-			curr := t.Current()
-			nt := curr.NodeType()
-			if nt == xpath.AttributeNode {
-				h := curr.LocalName()
-				if xsel.Name != "" {
-					h = xsel.Name
-				}
-				nheader[h] = len(nheader)
-				d[h] = curr.Value()
-			} else {
-				pref := ""
-				err = recFlattenNode(pref, curr, nheader, d, xsel.Name)
-				if err != nil {
-					return nil, nil, err
-				}
-			}
-
-			more_than_one := t.MoveNext()
-			if more_than_one {
-				return nil, nil, fmt.Errorf("more than one element found for " + sel + " in " + node.LocalName())
-			}
+		scanRepeats(rt.Current(), sels, topts.KeepPrefixes)
+	}
+	rt = rexp.Select(docnode)
+	for rt.MoveNext() {
+		nheader, d, err := flattenSelects(rt.Current(), sels, &flattenOptions{prefixes: topts.KeepPrefixes})
+		if err != nil {
+			return nil, nil, err
 		}
-
 		mergemap(mheader, nheader)
 		data = append(data, d)
 	}
@@ -250,11 +353,11 @@ func readTreeFile(path FileInfo, xpathstr string, xselects []Select, kind string
 	return header, data, nil
 }
 
-func addTreeFileToTable(db *sql.DB, info FileInfo, insert *sql.Stmt, header []string, xpathstr string, xselects []Select, kind string) error {
+func (m *Musql) addTreeFileToTable(info FileInfo, insert *sql.Stmt, header []string, xpathstr string, xselects []Select, kind string, topts TreeOptions, prov *provenance) error {
 	var nheader []string
 	var data []map[string]string
 	var err error
-	nheader, data, err = readTreeFile(info, xpathstr, xselects, kind)
+	nheader, data, err = m.readTreeFile(info, xpathstr, xselects, kind, topts)
 	if err != nil {
 		return err
 	}
@@ -262,12 +365,12 @@ func addTreeFileToTable(db *sql.DB, info FileInfo, insert *sql.Stmt, header []st
 	if err != nil {
 		return err
 	}
-	for _, d := range data {
+	for n, d := range data {
 		var row []interface{}
 		for _, h := range header {
 			row = append(row, d[h])
 		}
-		_, err = insert.Exec(row...)
+		_, err = insert.Exec(prov.values(row, info, n+1)...)
 		if err != nil {
 			return err
 		}
@@ -275,21 +378,188 @@ func addTreeFileToTable(db *sql.DB, info FileInfo, insert *sql.Stmt, header []st
 	return nil
 }
 
-func readHeaderCsv(info FileInfo, sep rune) ([]string, error) {
-	f, err := opencontainer(info)
+// CsvDialect describes the format of csv files
+type CsvDialect struct {
+	// field separator, ';' if not set
+	Sep rune
+	// quote character, '"' if not set
+	Quote rune
+	// lines starting with the comment character are ignored
+	Comment rune
+	// quotes may appear in unquoted fields, quoted fields may have single quotes
+	LazyQuotes bool
+	// remove the spaces around the fields
+	Trim bool
+	// number of lines (title, preamble) before the header
+	SkipLines int
+	// rows with more or less fields than the header are truncated or padded
+	VariableFields bool
+	// number of rows (totals, summaries) ignored at the end of the file
+	SkipFooter int
+	// detect the separator, the quote character and the header line
+	Sniff bool
+}
+
+// exchanges the quote character and '"' for the csv reader
+// (the fields are exchanged back after reading)
+type quoteSwapper struct {
+	r     io.Reader
+	quote byte
+}
+
+func (q *quoteSwapper) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == q.quote {
+			p[i] = '"'
+		} else if p[i] == '"' {
+			p[i] = q.quote
+		}
+	}
+	return n, err
+}
+
+type csvRows struct {
+	f       *FileContainer
+	r       *csv.Reader
+	dialect CsvDialect
+	header  []string
+	// no header line in the file, the column names are generated
+	generated bool
+	pending   [][]string
+	// lines of the pending rows and of the last row
+	lines []int
+	line  int
+}
+
+func (c *csvRows) quote() rune {
+	if c.dialect.Quote == 0 {
+		return '"'
+	}
+	return c.dialect.Quote
+}
+
+// open a csv file and read its header line (if csvheader is set)
+func (m *Musql) openCsv(info FileInfo, dialect CsvDialect, csvheader bool) (*csvRows, error) {
+	if dialect.Sep == 0 {
+		dialect.Sep = ';'
+	}
+	if dialect.Quote == '"' {
+		dialect.Quote = 0
+	}
+	if dialect.Quote >= utf8.RuneSelf {
+		return nil, fmt.Errorf("quote character '%c' of %s is not ascii", dialect.Quote, info.Path)
+	}
+	f, err := m.opencontainer(info)
 	if err != nil {
 		err = fmt.Errorf("%w: reading header of %s", err, info.Path)
 		return nil, err
 	}
-	defer f.Close()
-	r := csv.NewReader(f.file)
-	r.Comma = sep
-	header, err := r.Read()
+	br := bufio.NewReaderSize(f.file, sniffSize)
+	for n := 0; n < dialect.SkipLines; n++ {
+		_, err = br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%w: reading %s", err, info.Path)
+		}
+	}
+	var header []string
+	if dialect.Sniff {
+		sample, _ := br.Peek(sniffSize)
+		dialect.Sep, dialect.Quote, header = sniffCsv(sample, len(sample) < sniffSize)
+		if dialect.Quote == '"' {
+			dialect.Quote = 0
+		}
+	}
+	var r io.Reader = br
+	if dialect.Quote != 0 {
+		r = &quoteSwapper{r: br, quote: byte(dialect.Quote)}
+	}
+	c := &csvRows{f: f, r: csv.NewReader(r), dialect: dialect}
+	c.r.Comma = dialect.Sep
+	c.r.Comment = dialect.Comment
+	c.r.LazyQuotes = dialect.LazyQuotes
+	c.r.TrimLeadingSpace = dialect.Trim
+	if dialect.VariableFields {
+		c.r.FieldsPerRecord = -1
+	}
+	if header != nil && csvheader {
+		// no header line: generated column names
+		c.header = header
+		c.generated = true
+	} else if csvheader {
+		c.header, err = c.read()
+		if err != nil {
+			f.Close()
+			err = fmt.Errorf("%w: reading header of %s", err, info.Path)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// the next record with the quotes exchanged back and trimmed fields
+func (c *csvRows) read() ([]string, error) {
+	row, err := c.r.Read()
 	if err != nil {
-		err = fmt.Errorf("%w: reading header of %s", err, info.Path)
 		return nil, err
 	}
-	return header, nil
+	for i, v := range row {
+		if c.dialect.Quote != 0 {
+			v = strings.Map(func(r rune) rune {
+				if r == '"' {
+					return c.dialect.Quote
+				} else if r == c.dialect.Quote {
+					return '"'
+				}
+				return r
+			}, v)
+		}
+		if c.dialect.Trim {
+			v = strings.TrimSpace(v)
+		}
+		row[i] = v
+	}
+	if c.dialect.VariableFields && c.header != nil {
+		for len(row) < len(c.header) {
+			row = append(row, "")
+		}
+		row = row[:len(c.header)]
+	}
+	return row, nil
+}
+
+func (c *csvRows) Header() []string {
+	return c.header
+}
+
+func (c *csvRows) Read() ([]string, error) {
+	// keep the footer rows back until the end of the file
+	for len(c.pending) <= c.dialect.SkipFooter {
+		row, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		line, _ := c.r.FieldPos(0)
+		c.pending = append(c.pending, row)
+		c.lines = append(c.lines, line+c.dialect.SkipLines)
+	}
+	row := c.pending[0]
+	c.pending = c.pending[1:]
+	c.line = c.lines[0]
+	c.lines = c.lines[1:]
+	return row, nil
+}
+
+func (c *csvRows) lineNumber() int {
+	return c.line
+}
+
+func (c *csvRows) Close() {
+	c.f.Close()
 }
 
 func deleteAllFromTable(db *sql.DB, tablename string) error {
@@ -308,11 +578,13 @@ const (
 )
 
 // check if a table is already in the db and has the necessary columns
-func haveTable(db *sql.DB, tablename string, header []string) (int, error) {
-	rows, err := db.Query(fmt.Sprintf("select * from %s limit 1", tablename))
+// (and column types, no types meaning untyped columns)
+func haveTable(db *sql.DB, tablename string, header []string, types []string) (int, error) {
+	rows, err := db.Query(fmt.Sprintf("select * from \"%s\" limit 1", tablename))
 	if err != nil {
 		return no_table, nil
 	}
+	defer rows.Close()
 	columns, err := rows.Columns()
 	if err != nil {
 		return had_err, err
@@ -321,6 +593,19 @@ func haveTable(db *sql.DB, tablename string, header []string) (int, error) {
 	if err != nil {
 		return bad_table, nil
 	}
+	coltypes, err := rows.ColumnTypes()
+	if err != nil {
+		return had_err, err
+	}
+	for i, ct := range coltypes {
+		want := ""
+		if types != nil {
+			want = declaredType(types[i])
+		}
+		if strings.ToUpper(ct.DatabaseTypeName()) != want {
+			return bad_table, nil
+		}
+	}
 	for rows.Next() {
 		// read rest
 	}
@@ -341,17 +626,20 @@ func verifyHeader(collist1 []string, collist2 []string) error {
 
 func dropTable(db *sql.DB, tablename string) error {
 	_, err := db.Exec(fmt.Sprintf("drop table \"%s\"", tablename))
-	err = fmt.Errorf("%w: dropping table %s", err, tablename)
-	return err
+	if err != nil {
+		return fmt.Errorf("%w: dropping table %s", err, tablename)
+	}
+	return nil
 }
 
 // ensure that the table exists in the database with the given columns
-func ensureTable(db *sql.DB, tablename string, header []string) (err error) {
+// and column types (nil for untyped columns)
+func ensureTable(db *sql.DB, tablename string, header []string, types []string) (err error) {
 	if len(header) <= 0 {
 		return fmt.Errorf("creating " + tablename + ": empty header")
 	}
 	// able already available? columns of table matching?
-	info, err := haveTable(db, tablename, header)
+	info, err := haveTable(db, tablename, header, types)
 	if err != nil {
 		return err
 	}
@@ -365,6 +653,10 @@ func ensureTable(db *sql.DB, tablename string, header []string) (err error) {
 			return err
 		}
 	}
+	return createTable(db, tablename, header, types)
+}
+
+func createTable(db *sql.DB, tablename string, header []string, types []string) error {
 	var ddl []string
 	ddl = append(ddl, "create table")
 	ddl = append(ddl, "\""+tablename+"\"")
@@ -374,58 +666,137 @@ func ensureTable(db *sql.DB, tablename string, header []string) (err error) {
 			ddl = append(ddl, ",")
 		}
 		ddl = append(ddl, "\""+v+"\"")
+		if types != nil {
+			ddl = append(ddl, declaredType(types[i]))
+		}
 	}
 	ddl = append(ddl, ")")
 
-	_, err = db.Exec(strings.Join(ddl, " "))
+	_, err := db.Exec(strings.Join(ddl, " "))
 	if err != nil {
 		return fmt.Errorf("%w: creating %s", err, tablename)
 	}
 	return nil
 }
 
-func addCsvFileToTable(db *sql.DB, info FileInfo, insert *sql.Stmt, header []string, sep rune, csvheader bool) error {
-	f, err := opencontainer(info)
+// the columns of a table, nil if there is no such table
+func tableColumns(db *sql.DB, tablename string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("select * from \"%s\" limit 1", tablename))
 	if err != nil {
-		return err
+		return nil, nil
 	}
-	defer f.Close()
-	r := csv.NewReader(f.file)
-	r.Comma = sep
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// the columns of the table as a set for addMissingColumns
+func tableColumnSet(db *sql.DB, tablename string) (map[string]bool, error) {
+	columns, err := tableColumns(db, tablename)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool)
+	for _, c := range columns {
+		have[c] = true
+	}
+	return have, nil
+}
 
-	if csvheader {
-		row, err := r.Read() // header
+// prepare the table for the rows of an import: by default the rows
+// replace the table, in append and upsert mode they are added
+func prepareTable(db *sql.DB, tablename string, header []string, types []string, opts ImportOptions) error {
+	if opts.Mode == ModeReplace {
+		return ensureTable(db, tablename, header, types)
+	}
+	if len(header) <= 0 {
+		return fmt.Errorf("creating " + tablename + ": empty header")
+	}
+	columns, err := tableColumns(db, tablename)
+	if err != nil {
+		return err
+	}
+	if columns == nil {
+		err = createTable(db, tablename, header, types)
 		if err != nil {
 			return err
 		}
-		err = verifyHeader(header, row)
+	} else {
+		have := make(map[string]bool)
+		for _, c := range columns {
+			have[c] = true
+		}
+		for _, h := range header {
+			if !have[h] && !opts.MergeColumns {
+				return fmt.Errorf("table %s has no column %s (%s)", tablename, h, strings.Join(columns, ";"))
+			}
+		}
+		err = addMissingColumns(db, tablename, have, header, types)
 		if err != nil {
 			return err
 		}
 	}
-
-	for err == nil {
-		var row []string
-		row, err = r.Read()
-		if err == nil {
-			v := make([]interface{}, len(row))
-			for i, val := range row {
-				v[i] = val
+	if opts.Mode == ModeUpsert {
+		if len(opts.Keys) == 0 {
+			return fmt.Errorf("no key columns for upsert into %s", tablename)
+		}
+		var keys []string
+		for _, k := range opts.Keys {
+			found := false
+			for _, h := range header {
+				found = found || h == k
 			}
-			_, err = insert.Exec(v...)
+			if !found {
+				return fmt.Errorf("key column %s not found (%s)", k, strings.Join(header, ";"))
+			}
+			keys = append(keys, "\""+k+"\"")
+		}
+		idx := "_" + tablename + "_key_" + strings.Join(opts.Keys, "_")
+		_, err = db.Exec(fmt.Sprintf("create unique index if not exists \"%s\" on \"%s\" (%s)", idx, tablename, strings.Join(keys, ",")))
+		if err != nil {
+			return fmt.Errorf("%w: creating unique index on %s", err, tablename)
 		}
 	}
-	if err == io.EOF {
-		return nil
+	return nil
+}
+
+// *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// add the columns missing in the table (when merging the columns of several files),
+// have contains the columns of the table and is updated
+func addMissingColumns(db execer, tablename string, have map[string]bool, header []string, types []string) error {
+	for i, h := range header {
+		if have[h] {
+			continue
+		}
+		coltype := ""
+		if types != nil {
+			coltype = declaredType(types[i])
+		}
+		_, err := db.Exec(fmt.Sprintf("alter table \"%s\" add column \"%s\" %s", tablename, h, coltype))
+		if err != nil {
+			return fmt.Errorf("%w: adding column %s to %s", err, h, tablename)
+		}
+		have[h] = true
 	}
-	err = fmt.Errorf("%w: fill table from %s", err, info.Path)
-	return err
+	return nil
 }
 
-func makeInsert(tx *sql.Tx, tablename string, header []string) (*sql.Stmt, error) {
+// prepare the insert statement, with keys as upsert
+func makeInsert(tx *sql.Tx, tablename string, header []string, keys []string) (*sql.Stmt, error) {
 	var query []string
 	query = append(query, "insert into ")
 	query = append(query, "\""+tablename+"\"")
+	query = append(query, "(")
+	for i, v := range header {
+		if i != 0 {
+			query = append(query, ",")
+		}
+		query = append(query, "\""+v+"\"")
+	}
+	query = append(query, ")")
 	query = append(query, "values (")
 	for i := 0; i < len(header); i++ {
 		if i != 0 {
@@ -434,6 +805,29 @@ func makeInsert(tx *sql.Tx, tablename string, header []string) (*sql.Stmt, error
 		query = append(query, "?")
 	}
 	query = append(query, ")")
+	if len(keys) > 0 {
+		// upsert: update the rows with the same keys
+		iskey := make(map[string]bool)
+		for _, k := range keys {
+			iskey[k] = true
+		}
+		var set []string
+		for _, v := range header {
+			if !iskey[v] {
+				set = append(set, "\""+v+"\" = excluded.\""+v+"\"")
+			}
+		}
+		var keycols []string
+		for _, k := range keys {
+			keycols = append(keycols, "\""+k+"\"")
+		}
+		query = append(query, "on conflict ("+strings.Join(keycols, ",")+") do")
+		if len(set) == 0 {
+			query = append(query, "nothing")
+		} else {
+			query = append(query, "update set "+strings.Join(set, ", "))
+		}
+	}
 	stmt, err := tx.Prepare(strings.Join(query, " "))
 	if err != nil {
 		return nil, fmt.Errorf("%w preparing insert", err)
@@ -481,7 +875,7 @@ func (m *Musql) TablesToContext(mdata map[string]interface{}) error {
 	}
 
 	for _, obj := range objlist {
-		err := m.addData(mdata, obj, fmt.Sprintf("select * from %s", obj))
+		err := m.addData(mdata, obj, fmt.Sprintf("select * from \"%s\"", obj))
 		if err != nil {
 			return err
 		}
@@ -538,94 +932,58 @@ func (m *Musql) addData(mdata map[string]interface{}, resultvar string, stmt str
 }
 
 func (m *Musql) AddCsv(tablename string, path []FileInfo, sep rune) error {
-	err := m.addCsvFiles(tablename, path, sep, nil)
+	err := m.addCsvFiles(tablename, path, CsvDialect{Sep: sep}, ImportOptions{})
 	return err
 }
 
 func (m *Musql) AddCsvWithHeader(tablename string, path []FileInfo, sep rune, header []string) error {
-	err := m.addCsvFiles(tablename, path, sep, header)
+	var opts ImportOptions
+	for _, h := range header {
+		opts.Columns = append(opts.Columns, Column{Name: h})
+	}
+	err := m.addCsvFiles(tablename, path, CsvDialect{Sep: sep}, opts)
 	return err
 }
 
-func (m *Musql) addCsvFiles(tablename string, path []FileInfo, sep rune, inheader []string) error {
-	// read first csv -> header -> columns
-	if sep == 0 {
-		sep = ';'
-	}
-	var header []string
-	var insert *sql.Stmt
-	var tx *sql.Tx
-	var csvheader = true
-	var err error
+func (m *Musql) AddCsvWithOptions(tablename string, path []FileInfo, dialect CsvDialect, opts ImportOptions) error {
+	err := m.addCsvFiles(tablename, path, dialect, opts)
+	return err
+}
 
-	if inheader != nil {
-		header = inheader
-		csvheader = false
-		err = ensureTable(m.db, tablename, header)
-		if err != nil {
-			return err
-		}
-		tx, err = m.db.Begin()
-		if err != nil {
-			return err
-		}
-		defer tx.Commit()
-		insert, err = makeInsert(tx, tablename, header)
-		if err != nil {
-			return err
-		}
-	}
-	// add data from all files
-	for _, fileinfo := range path {
-		patt := fileinfo.Path
-		if fileinfo.Container != "" {
-			patt = fileinfo.Container
-		}
-		flist, err := filepath.Glob(patt)
-		if err != nil {
-			return err
-		}
-		if len(flist) == 0 {
-			return fmt.Errorf("file " + patt + " not found")
-		}
-		for _, fname := range flist {
-			f := FileInfo{Path: fname, Container: fileinfo.Container}
-			if len(header) == 0 {
-				header, err = readHeaderCsv(f, sep)
-				if err != nil {
-					return err
-				}
-				err = ensureTable(m.db, tablename, header)
-				if err != nil {
-					return err
-				}
-				tx, err = m.db.Begin()
-				if err != nil {
-					return err
-				}
-				defer tx.Commit()
-				insert, err = makeInsert(tx, tablename, header)
-				if err != nil {
-					return err
-				}
-			}
-			err = addCsvFileToTable(m.db, f, insert, header, sep, csvheader)
-			if err != nil {
-				return err
-			}
+func (m *Musql) addCsvFiles(tablename string, path []FileInfo, dialect CsvDialect, opts ImportOptions) error {
+	csvheader := len(opts.Columns) == 0 || opts.KeepHeader
+	reported := make(map[string]bool)
+	open := func(info FileInfo) (rowReader, error) {
+		c, err := m.openCsv(info, dialect, csvheader)
+		if err == nil && dialect.Sniff && !reported[info.Container+"/"+info.Path] {
+			reported[info.Container+"/"+info.Path] = true
+			m.logf("%s: separator %q, quote %q, header line %v", info.Path, c.dialect.Sep, c.quote(), !c.generated)
 		}
+		return c, err
 	}
-	return nil
+	return m.addRowFiles(tablename, path, open, opts)
 }
 
-func (m *Musql) AddFromTreeFile(tablename string, path []FileInfo, xpathstr string, xselects []Select, kind string) error {
+func (m *Musql) AddFromTreeFile(tablename string, path []FileInfo, xpathstr string, xselects []Select, kind string, topts TreeOptions, opts ImportOptions) error {
+	if topts.Children {
+		return m.addTreeTables(tablename, path, xpathstr, xselects, kind, topts, opts)
+	}
 	var header []string
-	var err error
-	header, _, err = readTreeFile(path[0], xpathstr, xselects, kind)
+	path, err := globFiles(path)
 	if err != nil {
 		return err
 	}
-	err = ensureTable(m.db, tablename, header)
+	header, _, err = m.readTreeFile(path[0], xpathstr, xselects, kind, topts)
+	if err != nil {
+		return err
+	}
+	prov := newProvenance(opts)
+	columns, _ := prov.columns(header, nil)
+	err = prepareTable(m.db, tablename, columns, nil, opts)
+	if err != nil {
+		return err
+	}
+	have, err := tableColumnSet(m.db, tablename)
 	if err != nil {
 		return err
 	}
@@ -635,13 +993,30 @@ func (m *Musql) AddFromTreeFile(tablename string, path []FileInfo, xpathstr stri
 	}
 	defer tx.Commit()
 
-	insert, err := makeInsert(tx, tablename, header)
+	insert, err := makeInsert(tx, tablename, columns, opts.upsertKeys())
 	if err != nil {
 		return err
 	}
 	// add data from all files
-	for _, filename := range path {
-		err = addTreeFileToTable(m.db, filename, insert, header, xpathstr, xselects, kind)
+	for i, filename := range path {
+		fheader := header
+		finsert := insert
+		if opts.MergeColumns && i > 0 {
+			fheader, _, err = m.readTreeFile(filename, xpathstr, xselects, kind, topts)
+			if err != nil {
+				return err
+			}
+			fcolumns, _ := prov.columns(fheader, nil)
+			err = addMissingColumns(tx, tablename, have, fcolumns, nil)
+			if err != nil {
+				return err
+			}
+			finsert, err = makeInsert(tx, tablename, fcolumns, opts.upsertKeys())
+			if err != nil {
+				return err
+			}
+		}
+		err = m.addTreeFileToTable(filename, finsert, fheader, xpathstr, xselects, kind, topts, prov)
 		if err != nil {
 			break
 		}
@@ -653,63 +1028,18 @@ func (m *Musql) AddFromTreeFile(tablename string, path []FileInfo, xpathstr stri
 }
 
 func (m *Musql) AddXml(tablename string, path []FileInfo, xpathstr string, xselects []Select) error {
-	err := m.AddFromTreeFile(tablename, path, xpathstr, xselects, "xml")
+	err := m.AddFromTreeFile(tablename, path, xpathstr, xselects, "xml", TreeOptions{}, ImportOptions{})
 	return err
 }
 
 func (m *Musql) AddJson(tablename string, path []FileInfo, xpathstr string, xselects []Select) error {
-	err := m.AddFromTreeFile(tablename, path, xpathstr, xselects, "json")
+	err := m.AddFromTreeFile(tablename, path, xpathstr, xselects, "json", TreeOptions{}, ImportOptions{})
 	return err
 }
 
-func (m *Musql) AddFiles(tablename string, root string, withcontent bool) error {
-	header := []string{"fullpath", "filename", "content"}
-	err := ensureTable(m.db, tablename, header)
-	if err != nil {
-		return err
-	}
-
-	tx, err := m.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Commit()
-
-	insert, err := makeInsert(tx, tablename, header)
-	if err != nil {
-		return err
-	}
-	err = filepath.Walk(root, func(fullpath string, f os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if f.IsDir() {
-			return nil
-		}
-		content := ""
-		if withcontent {
-			b, err := ioutil.ReadFile(fullpath)
-			if err != nil {
-				return err
-			}
-			content = string(b)
-		}
-		fn := path.Base(fullpath)
-		_, err = insert.Exec(fullpath, fn, content)
-		if err != nil {
-			return fmt.Errorf("%w: storing file info for %s", err, fn)
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (m *Musql) AddParameters(tablename string, params map[string]string) error {
 	header := []string{"paramkey", "value"}
-	err := ensureTable(m.db, tablename, header)
+	err := ensureTable(m.db, tablename, header, nil)
 	if err != nil {
 		return err
 	}
@@ -720,7 +1050,7 @@ func (m *Musql) AddParameters(tablename string, params map[string]string) error
 	}
 	defer tx.Commit()
 
-	insert, err := makeInsert(tx, tablename, header)
+	insert, err := makeInsert(tx, tablename, header, nil)
 	for key, value := range params {
 		_, err = insert.Exec(key, value)
 		if err != nil {
@@ -1,10 +1,45 @@
 package internal
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 )
 
+func writeTestFile(t *testing.T, dir string, name string, content string) string {
+	fname := filepath.Join(dir, name)
+	err := os.WriteFile(fname, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return fname
+}
+
+func queryStrings(t *testing.T, m *Musql, stmt string) []string {
+	rows, err := m.db.Query(stmt)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
 func TestBasics(t *testing.T) {
 	var m = &Musql{}
 	m.NewDb()
@@ -112,3 +147,916 @@ func TestWith(t *testing.T) {
 		t.Errorf("bad: >%s< <> >%s<", out.String(), expect)
 	}
 }
+
+func TestCsvTypes(t *testing.T) {
+	fname := writeTestFile(t, t.TempDir(), "types.csv", "Name;Wert;Datum;Id\na;4000;2021-01-31;007\nb;30000;2021-02-28;012\nc;1.5;;013\n")
+	var m = &Musql{}
+	m.NewDb()
+	defer m.Close()
+	err := m.AddCsv("typed", []FileInfo{FileInfo{Path: fname}}, ';')
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select Wert from typed order by Wert"), ",")
+	if got != "1.5,4000,30000" {
+		t.Errorf("bad order: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select typeof(Wert) || typeof(Datum) || typeof(Id) from typed where Name = 'c'"), ",")
+	if got != "realnulltext" {
+		t.Errorf("bad types: %s", got)
+	}
+
+	err = m.AddCsvWithOptions("untyped", []FileInfo{FileInfo{Path: fname}}, CsvDialect{Sep: ';'}, ImportOptions{Untyped: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select Wert from untyped order by Wert"), ",")
+	if got != "1.5,30000,4000" {
+		t.Errorf("bad order: %s", got)
+	}
+}
+
+func TestTemplateDates(t *testing.T) {
+	dir := t.TempDir()
+	fname := writeTestFile(t, dir, "dates.csv", "Name;Datum;Zeit\na;2021-12-31;2021-12-31 10:30:00\n")
+	dname := writeTestFile(t, dir, "de.csv", "Name;Datum\nb;31.12.2021\n")
+	var m = &Musql{}
+	m.NewDb()
+	defer m.Close()
+	err := m.AddCsvWithOptions("d", []FileInfo{FileInfo{Path: fname}}, CsvDialect{}, ImportOptions{Provenance: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = m.AddCsvWithOptions("l", []FileInfo{FileInfo{Path: dname}}, CsvDialect{}, ImportOptions{Locale: "de"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	out := bytes.NewBufferString("")
+	err = m.RunTemplate(`{{#d}}{{Name}}:{{Datum}}:{{Zeit}}:{{_imported}}{{/d}} {{#l}}{{Name}}:{{Datum}}{{/l}}`, out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ok, _ := regexp.MatchString(`^a:2021-12-31:2021-12-31 10:30:00:\d{4}-\d\d-\d\d \d\d:\d\d:\d\d b:2021-12-31$`, out.String()); !ok {
+		t.Errorf("bad dates: %s", out.String())
+	}
+}
+
+func TestCsvColumns(t *testing.T) {
+	dir := t.TempDir()
+	withheader := writeTestFile(t, dir, "h.csv", "id;amount;date;note\n1;10;2021-03-01;x\n2;9.5;2021-03-02;y\n")
+	noheader := writeTestFile(t, dir, "n.csv", "1;10;x\n2;9.5;y\n")
+
+	var c = &Config{}
+	err := c.Parse([]string{
+		"insert", withheader, "into", "a", "with", "id:int", "amount:real:Betrag", "date:date", "skip", "as", "columns",
+		"insert", noheader, "into", "b", "with", "id", "skip", ":text:note", "as", "header",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select id || ':' || Betrag || ':' || typeof(Betrag) || ':' || date from a order by Betrag"), ",")
+	if got != "2:9.5:real:2021-03-02,1:10.0:real:2021-03-01" {
+		t.Errorf("bad table a: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select id || ':' || note from b order by id"), ",")
+	if got != "1:x,2:y" {
+		t.Errorf("bad table b: %s", got)
+	}
+
+	err = m.AddCsvWithOptions("c", []FileInfo{FileInfo{Path: withheader}}, CsvDialect{Sep: ';'}, ImportOptions{Columns: []Column{Column{Name: "nr"}}, KeepHeader: true})
+	if err == nil {
+		t.Errorf("expecting error for wrong column name")
+	}
+	err = m.AddCsvWithOptions("d", []FileInfo{FileInfo{Path: noheader}}, CsvDialect{Sep: ';'}, ImportOptions{Columns: []Column{Column{Name: "id"}, Column{Name: "amount"}}})
+	if err == nil {
+		t.Errorf("expecting error for more values than declared columns")
+	}
+}
+
+func TestJsonl(t *testing.T) {
+	fname := writeTestFile(t, t.TempDir(), "events.jsonl", `{"id": 1, "level": "info", "user": {"name": "a"}}
+
+{"id": 2, "level": "error", "msg": "failed", "user": {"name": "b"}}
+{"id": 10, "level": "info", "user": {"name": "c"}}
+`)
+	var m = &Musql{}
+	m.NewDb()
+	defer m.Close()
+	err := m.AddJsonl("events", []FileInfo{FileInfo{Path: fname}}, "", nil, ImportOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, `select id || ':' || level || ':' || msg || ':' || name from events order by id`), ",")
+	if got != "1:info::a,2:error:failed:b,10:info::c" {
+		t.Errorf("bad rows: %s", got)
+	}
+
+	var c = &Config{}
+	err = c.Parse([]string{"insert", fname, "into", "users", "using", "id", "as", "nr", "user/name", "as", "name", "from", "xpath", "."})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, `select nr || ':' || name from users order by nr`), ",")
+	if got != "1:a,2:b,10:c" {
+		t.Errorf("bad rows: %s", got)
+	}
+
+	tagged := writeTestFile(t, t.TempDir(), "tags.jsonl", `{"id": 1, "tags": ["x"]}
+{"id": 2, "tags": ["x", "y"]}
+`)
+	err = m.AddJsonl("tags", []FileInfo{FileInfo{Path: tagged}}, "", nil, ImportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "tags") || !strings.Contains(err.Error(), "repeated") {
+		t.Errorf("expecting error naming the array and the repeated option, got %v", err)
+	}
+	err = m.AddJsonl("tags", []FileInfo{FileInfo{Path: tagged}}, "", []Select{Select{Path: ".", Repeat: RepeatJSON}}, ImportOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, `select id || ':' || tags from tags order by id`), ",")
+	if got != `1:["x"],2:["x","y"]` {
+		t.Errorf("bad rows: %s", got)
+	}
+}
+
+func TestRegex(t *testing.T) {
+	fname := writeTestFile(t, t.TempDir(), "app.log", `2021-03-01T10:00:00 INFO started
+2021-03-01T10:00:05 ERROR failed
+  at main.go:12
+  at lib.go:7
+2021-03-01T10:00:09 INFO done
+`)
+	var c = &Config{}
+	err := c.Parse([]string{"insert", fname, "into", "log", "as", "regex", `'^(?P<ts>\S+)`, `(?P<level>\w+)`, `(?P<msg>.*)$'`, "continuation", "linenumbers"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, `select line || ':' || level || ':' || msg from log order by line`), ",")
+	if got != "1:INFO:started,2:ERROR:failed\n  at main.go:12\n  at lib.go:7,5:INFO:done" {
+		t.Errorf("bad rows: %s", got)
+	}
+}
+
+func TestFixed(t *testing.T) {
+	dir := t.TempDir()
+	zipname := filepath.Join(dir, "drop.zip")
+	writeTestZip(t, zipname, map[string]string{
+		"accounts.txt": "00000001Müller                     1234.50\n00000002Meier                        -7.00\n\n",
+	})
+	var c = &Config{}
+	err := c.Parse([]string{"insert", "accounts.txt", "from", zipname, "into", "acc", "as", "fixed", "with", "id:text:1-8", "name:9-35", "amount:36-43"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, `select id || ':' || name || ':' || amount from acc order by amount`), ",")
+	if got != "00000002:Meier:-7.0,00000001:Müller:1234.5" {
+		t.Errorf("bad rows: %s", got)
+	}
+}
+
+func TestCompressedContainers(t *testing.T) {
+	dir := t.TempDir()
+	content := "Name;Wert\na;1\nb;2\n"
+
+	// x.csv inside drop.tar.gz
+	tgz, err := os.Create(filepath.Join(dir, "drop.tar.gz"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	zw := gzip.NewWriter(tgz)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "./x.csv", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(content))
+	tw.Close()
+	zw.Close()
+	tgz.Close()
+
+	// compressed y.csv.gz
+	gz, err := os.Create(filepath.Join(dir, "y.csv.gz"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	zw = gzip.NewWriter(gz)
+	zw.Write([]byte(content))
+	zw.Close()
+	gz.Close()
+
+	var m = &Musql{}
+	m.NewDb()
+	defer m.Close()
+	err = m.AddCsv("a", []FileInfo{FileInfo{Path: "x.csv", Container: filepath.Join(dir, "drop.tar.gz")}, FileInfo{Path: filepath.Join(dir, "y.csv.gz")}}, ';')
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select sum(Wert) from a"), ",")
+	if got != "6" {
+		t.Errorf("bad sum: %s", got)
+	}
+
+	err = m.AddCsv("b", []FileInfo{FileInfo{Path: "missing.csv", Container: filepath.Join(dir, "drop.tar.gz")}}, ';')
+	if err == nil {
+		t.Errorf("expecting error for missing member")
+	}
+}
+
+func TestContainerGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeTestZip(t, filepath.Join(dir, "bundle1.zip"), map[string]string{
+		"reports/a.csv":     "Name;Wert\na;1\n",
+		"reports/sub/b.csv": "Name;Wert\nb;2\n",
+		"other/c.csv":       "Name;Wert\nc;4\n",
+	})
+	writeTestZip(t, filepath.Join(dir, "bundle2.zip"), map[string]string{
+		"reports/d.csv": "Name;Wert\nd;8\n",
+	})
+	var m = &Musql{}
+	m.NewDb()
+	defer m.Close()
+	err := m.AddCsv("r", []FileInfo{FileInfo{Path: "reports/**/*.csv", Container: filepath.Join(dir, "bundle*.zip")}}, ';')
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select sum(Wert) from r"), ",")
+	if got != "11" {
+		t.Errorf("bad sum: %s", got)
+	}
+	err = m.AddCsv("s", []FileInfo{FileInfo{Path: "nothing/*.csv", Container: filepath.Join(dir, "bundle*.zip")}}, ';')
+	if err == nil {
+		t.Errorf("expecting error for unmatched pattern")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestFile(t, dir, "a.csv", "Name;Wert\na;1\nb;2\n")
+	b := writeTestFile(t, dir, "b.csv", "Name;Wert\nc;3\n")
+	x := writeTestFile(t, dir, "x.xml", `<list><item id="1"/><item id="2"/></list>`)
+	var c = &Config{}
+	err := c.Parse([]string{
+		"insert", a, b, "into", "p", "with", "provenance",
+		"insert", x, "into", "px", "with", "provenance", "xpath", "//item",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select Name || ':' || _file || ':' || _row || ':' || coalesce(_container, '-') from p where _imported is not null order by Name"), ",")
+	expect := "a:" + a + ":1:-,b:" + a + ":2:-,c:" + b + ":1:-"
+	if got != expect {
+		t.Errorf("bad rows: %s <> %s", got, expect)
+	}
+	got = strings.Join(queryStrings(t, m, "select id || ':' || _row from px order by id"), ",")
+	if got != "1:1,2:2" {
+		t.Errorf("bad rows: %s", got)
+	}
+}
+
+func TestMergeColumns(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestFile(t, dir, "a.csv", "Name;Wert\na;1\n")
+	b := writeTestFile(t, dir, "b.csv", "Wert;Name;Extra\n2;b;x\n")
+	x1 := writeTestFile(t, dir, "x1.xml", `<list><item id="1"/></list>`)
+	x2 := writeTestFile(t, dir, "x2.xml", `<list><item id="2" extra="y"/></list>`)
+	var m = &Musql{}
+	m.NewDb()
+	defer m.Close()
+	err := m.AddCsv("nomerge", []FileInfo{FileInfo{Path: a}, FileInfo{Path: b}}, ';')
+	if err == nil {
+		t.Errorf("expecting error for header mismatch")
+	}
+	err = m.AddCsvWithOptions("merged", []FileInfo{FileInfo{Path: a}, FileInfo{Path: b}}, CsvDialect{Sep: ';'}, ImportOptions{MergeColumns: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select Name || ':' || Wert || ':' || coalesce(Extra, 'null') from merged order by Name"), ",")
+	if got != "a:1:null,b:2:x" {
+		t.Errorf("bad rows: %s", got)
+	}
+	err = m.AddFromTreeFile("xmerged", []FileInfo{FileInfo{Path: x1}, FileInfo{Path: x2}}, "//item", nil, "xml", TreeOptions{}, ImportOptions{MergeColumns: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select id || ':' || coalesce(extra, 'null') from xmerged order by id"), ",")
+	if got != "1:null,2:y" {
+		t.Errorf("bad rows: %s", got)
+	}
+}
+
+func TestAppendUpsert(t *testing.T) {
+	dir := t.TempDir()
+	day1 := writeTestFile(t, dir, "day1.csv", "id;Wert\n1;10\n2;20\n")
+	day2 := writeTestFile(t, dir, "day2.csv", "id;Wert\n2;25\n3;30\n")
+	dbname := filepath.Join(dir, "history.db")
+	for _, day := range []string{day1, day2} {
+		var c = &Config{}
+		err := c.Parse([]string{
+			"db", dbname,
+			"insert", day, "into", "history", "append",
+			"insert", day, "into", "current", "upsert", "on", "(id)",
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var m = &Musql{}
+		err = c.Apply(m)
+		m.Close()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	var m = &Musql{}
+	m.OpenDb(dbname)
+	defer m.Close()
+	got := strings.Join(queryStrings(t, m, "select id || ':' || Wert from history order by id, Wert"), ",")
+	if got != "1:10,2:20,2:25,3:30" {
+		t.Errorf("bad appended rows: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select id || ':' || Wert from current order by id"), ",")
+	if got != "1:10,2:25,3:30" {
+		t.Errorf("bad upserted rows: %s", got)
+	}
+
+	// merged columns the table already has
+	wide := writeTestFile(t, dir, "wide.csv", "id;Wert;y\n4;40;x\n")
+	err := m.AddCsvWithOptions("history", []FileInfo{FileInfo{Path: wide}}, CsvDialect{}, ImportOptions{Mode: ModeAppend, MergeColumns: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = m.AddCsvWithOptions("history", []FileInfo{FileInfo{Path: day1}, FileInfo{Path: wide}}, CsvDialect{}, ImportOptions{Mode: ModeAppend, MergeColumns: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select count(*) || ':' || count(y) from history"), ",")
+	if got != "8:2" {
+		t.Errorf("bad merged rows: %s", got)
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	dir := t.TempDir()
+	fname := writeTestFile(t, dir, "a.csv", "id;Wert\n1;10\n")
+	other := writeTestFile(t, dir, "b.csv", "id;Wert\n9;90\n")
+	dbname := filepath.Join(dir, "cache.db")
+	run := func() {
+		var c = &Config{}
+		err := c.Parse([]string{"db", dbname, "incremental", "insert", fname, "into", "a", "append", "insert", other, "into", "a", "append"})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var m = &Musql{}
+		defer m.Close()
+		err = c.Apply(m)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	count := func() string {
+		var m = &Musql{}
+		m.OpenDb(dbname)
+		defer m.Close()
+		return strings.Join(queryStrings(t, m, "select count(*) from a"), ",")
+	}
+	run()
+	run()
+	run()
+	if got := count(); got != "2" {
+		t.Errorf("unchanged file imported again: %s rows", got)
+	}
+	writeTestFile(t, dir, "a.csv", "id;Wert\n2;20\n")
+	run()
+	if got := count(); got != "3" {
+		t.Errorf("changed file not imported: %s rows", got)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "docs", "old"), 0755)
+	os.MkdirAll(filepath.Join(dir, "tmp"), 0755)
+	writeTestFile(t, dir, "readme.md", "hello")
+	writeTestFile(t, dir, "docs/a.txt", "a")
+	writeTestFile(t, dir, "docs/old/b.txt", "b")
+	writeTestFile(t, dir, "tmp/c.txt", "c")
+	os.Symlink("readme.md", filepath.Join(dir, "link.md"))
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", dir, "into", "f", "exclude", "tmp", "maxdepth", "2", "with", "hash"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select relpath || ':' || dir || ':' || ext || ':' || depth || ':' || size || ':' || coalesce(linktarget, '-') from f order by relpath"), ",")
+	if got != "docs/a.txt:docs:.txt:2:1:-,link.md:.:.md:1:9:readme.md,readme.md:.:.md:1:5:-" {
+		t.Errorf("bad files: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select sha256 from f where relpath = 'readme.md'"), ",")
+	if got != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("bad hash: %s", got)
+	}
+
+	err = m.AddFiles("g", dir, FileOptions{Include: []string{"**/*.txt"}}, ImportOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select relpath from g order by relpath"), ",")
+	if got != "docs/a.txt,docs/old/b.txt,tmp/c.txt" {
+		t.Errorf("bad files: %s", got)
+	}
+}
+
+func TestFileContent(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", "grüße\n")
+	writeTestFile(t, dir, "b.txt", "gr\xfc\xdfe\n")
+	writeTestFile(t, dir, "c.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	writeTestFile(t, dir, "d.txt", strings.Repeat("x", 100))
+	target := writeTestFile(t, t.TempDir(), "big.txt", strings.Repeat("y", 200000))
+	if err := os.Symlink(target, filepath.Join(dir, "e.txt")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", dir, "into", "f", "maxsize", "50", "with", "content"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select filename || ':' || mime || ':' || encoding || ':' || coalesce(typeof(content), '-') || ':' || coalesce(length(content), '-') from f order by filename"), ",")
+	if got != "a.txt:text/plain:utf-8:text:6,b.txt:text/plain:iso-8859-1:text:6,c.png:image/png:binary:blob:16,d.txt:text/plain:ascii:null:-,e.txt:text/plain:ascii:null:-" {
+		t.Errorf("bad content: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select content from f where filename = 'b.txt'"), ",")
+	if got != "grüße\n" {
+		t.Errorf("bad text: %q", got)
+	}
+
+	for _, size := range []string{"", "k", "0", "9999999999g"} {
+		c = &Config{}
+		err = c.Parse([]string{"insert", dir, "into", "f", "maxsize", size})
+		if err == nil {
+			t.Errorf("expecting error for maxsize '%s'", size)
+		}
+	}
+
+	// hash of a file larger than the part read for the type
+	big := strings.Repeat("0123456789", 2000)
+	bigdir := t.TempDir()
+	writeTestFile(t, bigdir, "big.txt", big)
+	err = m.AddFiles("h", bigdir, FileOptions{Hash: true}, ImportOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	sum := sha256.Sum256([]byte(big))
+	got = strings.Join(queryStrings(t, m, "select sha256 || ':' || encoding || ':' || coalesce(content, '-') from h"), ",")
+	if got != hex.EncodeToString(sum[:])+":ascii:-" {
+		t.Errorf("bad hash: %s", got)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	defer func() { stdin = os.Stdin }()
+	stdin = strings.NewReader(`{"id": 1, "name": "a"}` + "\n" + `{"id": 2, "name": "b"}` + "\n")
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", "-", "into", "j", "as", "jsonl"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select id || ':' || name from j order by id"), ",")
+	if got != "1:a,2:b" {
+		t.Errorf("bad rows: %s", got)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "data.txt", "1;2\n3;4\n")
+	ini := writeTestFile(t, dir, "musql.ini", `insert command "sh -c 'echo a\;b; cat data.txt'" into t`)
+
+	var c = &Config{}
+	err := c.Parse([]string{"ini", ini})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err == nil {
+		t.Fatalf("expecting error for command without 'allow commands'")
+	}
+
+	c = &Config{}
+	err = c.Parse([]string{"ini", ini, "allow", "commands"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select sum(a) || ':' || sum(b) from t"), ",")
+	if got != "4:6" {
+		t.Errorf("bad sums: %s", got)
+	}
+
+	// the output of the command is not compressed like the file
+	gz, err := os.Create(filepath.Join(dir, "data.csv.gz"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	zw := gzip.NewWriter(gz)
+	zw.Write([]byte("a;b\n5;6\n"))
+	zw.Close()
+	gz.Close()
+	c = &Config{}
+	err = c.Parse([]string{"insert", "command", "gzip -dc " + gz.Name(), "into", "u", "allow", "commands"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select a || ':' || b from u"), ",")
+	if got != "5:6" {
+		t.Errorf("bad rows: %s", got)
+	}
+
+	writeTestFile(t, dir, "other.ini", "allow commands")
+	c = &Config{}
+	err = c.Parse([]string{"ini", filepath.Join(dir, "other.ini")})
+	if err == nil {
+		t.Errorf("expecting error for 'allow commands' in ini file")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	fname := writeTestFile(t, dir, "doc.xml", `<Document xmlns="urn:iso:pain" xmlns:x="urn:ext">
+	<Tx><Id>1</Id><x:Id>a</x:Id></Tx>
+	<Tx><Id>2</Id><x:Id>b</x:Id></Tx>
+</Document>`)
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", fname, "into", "tx", "as", "xml", "namespace", "p=urn:iso:pain", "namespace", "e=urn:ext",
+		"keep", "prefixes", "using", "p:Id", "e:Id", "from", "xpath", "/p:Document/p:Tx"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, `select "p:Id" || ':' || "e:Id" from tx order by 1`), ",")
+	if got != "1:a,2:b" {
+		t.Errorf("bad rows: %s", got)
+	}
+}
+
+func TestChildTables(t *testing.T) {
+	dir := t.TempDir()
+	xname := writeTestFile(t, dir, "orders.xml", `<orders>
+	<order id="1"><customer>a</customer><line><sku>x</sku><qty>2</qty></line><line><sku>y</sku><qty>1</qty></line>
+		<gift-note>hi</gift-note><gift-note>bye</gift-note></order>
+	<order id="2"><customer>b</customer><line><sku>z</sku><qty>5</qty></line></order>
+</orders>`)
+	jname := writeTestFile(t, dir, "orders.json", `{"orders": [{"id": 1, "tags": ["new", "paid"]}, {"id": 2, "tags": []}]}`)
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", xname, "into", "orders", "with", "children", "xpath", "//order",
+		"insert", jname, "into", "j", "with", "children", "xpath", "/orders/*"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select o.id || ':' || o.customer || ':' || l.sku || ':' || l.qty from orders o join orders_line l on l._parent_id = o._id order by l._id"), ",")
+	if got != "1:a:x:2,1:a:y:1,2:b:z:5" {
+		t.Errorf("bad order lines: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select j.id || ':' || t.tags from j join j_tags t on t._parent_id = j._id order by t._id"), ",")
+	if got != "1:new,1:paid" {
+		t.Errorf("bad tags: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, `select o.id || ':' || n."gift-note" from orders o join orders_gift_note n on n._parent_id = o._id order by n._id`), ",")
+	if got != "1:hi,1:bye" {
+		t.Errorf("bad notes: %s", got)
+	}
+	err = m.TablesToContext(map[string]interface{}{})
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestTree(t *testing.T) {
+	dir := t.TempDir()
+	xname := writeTestFile(t, dir, "doc.xml", `<?xml version="1.0"?>
+<orders><order id="1"><line>x</line><line>y</line></order></orders>`)
+	jname := writeTestFile(t, dir, "doc.json", `{"a": {"b": [1, 2]}}`)
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", xname, "into", "x", "as", "xmltree", "insert", jname, "into", "j", "as", "jsontree"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select id || ':' || coalesce(parent_id, '-') || ':' || depth || ':' || kind || ':' || name || ':' || coalesce(value, '-') || ':' || position || ':' || path from x order by id"), ",")
+	if got != "1:-:0:document::-:1:/,2:1:1:element:orders:-:1:/orders[1],3:2:2:element:order:-:1:/orders[1]/order[1],4:3:3:attribute:id:1:1:/orders[1]/order[1]/@id,5:3:3:element:line:x:1:/orders[1]/order[1]/line[1],6:3:3:element:line:y:2:/orders[1]/order[1]/line[2]" {
+		t.Errorf("bad nodes: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select path || '=' || value from j where value is not null order by id"), ",")
+	if got != "/a[1]/b[1]/*[1]=1,/a[1]/b[1]/*[2]=2" {
+		t.Errorf("bad json nodes: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, `with recursive up(id, parent_id, name) as (select id, parent_id, name from x where value = 'y'
+		union all select x.id, x.parent_id, x.name from x join up on x.id = up.parent_id) select group_concat(name, '<') from up where name != ''`), ",")
+	if got != "line<order<orders" {
+		t.Errorf("bad ancestors: %s", got)
+	}
+}
+
+func TestRepeated(t *testing.T) {
+	dir := t.TempDir()
+	xname := writeTestFile(t, dir, "orders.xml", `<orders>
+	<order><id>1</id><item>x</item><item>y</item></order>
+	<order><id>2</id><item>z</item></order>
+</orders>`)
+	jname := writeTestFile(t, dir, "doc.json", `{"rows": [{"id": 1, "tags": ["a", "b"]}]}`)
+
+	var c = &Config{}
+	err := c.Parse([]string{
+		"insert", xname, "into", "joined", "using", "id", "item", "repeated", "join", "|", "from", "xpath", "//order",
+		"insert", xname, "into", "indexed", "repeated", "index", "xpath", "//order",
+		"insert", xname, "into", "first", "repeated", "first", "xpath", "//order",
+		"insert", xname, "into", "items", "repeated", "json", "xpath", "//order",
+		"insert", xname, "into", "selected", "using", "id", "item", "repeated", "index", "from", "xpath", "//order",
+		"insert", jname, "into", "arrays", "as", "json", "repeated", "json", "xpath", "/rows/*"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select id || ':' || item from joined order by id"), ",")
+	if got != "1:x|y,2:z" {
+		t.Errorf("bad joined values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, `select id || ':' || "item/1" || ':' || "item/2" from indexed order by id`), ",")
+	if got != "1:x:y,2:z:" {
+		t.Errorf("bad indexed values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, `select id || ':' || "item/1" || ':' || "item/2" from selected order by id`), ",")
+	if got != "1:x:y,2:z:" {
+		t.Errorf("bad selected values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select id || ':' || item from first order by id"), ",")
+	if got != "1:x,2:z" {
+		t.Errorf("bad first values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select id || ':' || item from items order by id"), ",")
+	if got != `1:["x","y"],2:["z"]` {
+		t.Errorf("bad json items: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select id || ':' || tags from arrays"), ",")
+	if got != `1:["a","b"]` {
+		t.Errorf("bad json values: %s", got)
+	}
+}
+
+func TestCsvDialect(t *testing.T) {
+	dir := t.TempDir()
+	fname := writeTestFile(t, dir, "export.csv", `Report 2021
+exported by someone
+name, wert, note
+# a comment
+ a , 1, 'x, y'
+b, 2, 'it''s'
+c, 3
+d, 4, z, extra
+Total, 10,
+`)
+	var c = &Config{}
+	err := c.Parse([]string{"insert", fname, "into", "t", "separator", ",", "quote", "'", "comment", "#", "trim", "fields",
+		"variable", "fields", "skip", "lines", "2", "skip", "footer", "1"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select name || ':' || wert || ':' || note from t order by wert"), ",")
+	if got != "a:1:x, y,b:2:it's,c:3:,d:4:z" {
+		t.Errorf("bad rows: %s", got)
+	}
+
+	fname = writeTestFile(t, dir, "lazy.csv", "a;b\nsay \"hi\";2\n")
+	err = m.AddCsvWithOptions("l", []FileInfo{FileInfo{Path: fname}}, CsvDialect{LazyQuotes: true}, ImportOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select a from l"), ",")
+	if got != `say "hi"` {
+		t.Errorf("bad lazy quotes: %s", got)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	dir := t.TempDir()
+	bom := writeTestFile(t, dir, "bom.csv", "\xef\xbb\xbfName;Wert\nGr\xc3\xbc\xc3\x9fe;1\n")
+	cp := writeTestFile(t, dir, "cp.csv", "Name;Wert\nGr\xfc\xdfe \x80;2\n")
+	utf16 := writeTestFile(t, dir, "u16.csv", "\xff\xfeN\x00a\x00m\x00e\x00;\x00W\x00e\x00r\x00t\x00\n\x00\xfc\x00;\x003\x00\n\x00")
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", bom, "into", "a", "insert", cp, "into", "b", "encoding", "windows-1252", "insert", utf16, "into", "c"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select Name || ':' || Wert from a union all select Name || ':' || Wert from b union all select Name || ':' || Wert from c"), ",")
+	if got != "Grüße:1,Grüße €:2,ü:3" {
+		t.Errorf("bad values: %s", got)
+	}
+}
+
+func TestCsvSniff(t *testing.T) {
+	dir := t.TempDir()
+	comma := writeTestFile(t, dir, "comma.csv", "Name,Wert,Tag\n\"a, b\",1,2021-01-02\nc,2,2021-01-03\n")
+	tabs := writeTestFile(t, dir, "tabs.csv", "x\t1\t2\ny\t3\t4\n")
+	quotes := writeTestFile(t, dir, "quotes.csv", "'a|b'|1\n'c'|2\n'd'|3\n")
+	names := writeTestFile(t, dir, "names.csv", "Müller;Berlin\nMeier;Hamburg\n")
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", comma, "into", "a", "separator", "auto",
+		"insert", tabs, "into", "b", "separator", "auto",
+		"insert", quotes, "into", "c", "separator", "auto",
+		"insert", names, "into", "d", "separator", "auto"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var log bytes.Buffer
+	var m = &Musql{verbose: &log}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select Name || ':' || Wert || ':' || Tag from a"), ",")
+	if got != "a, b:1:2021-01-02,c:2:2021-01-03" {
+		t.Errorf("bad values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select c1 || ':' || (c2 + c3) from b"), ",")
+	if got != "x:3,y:7" {
+		t.Errorf("bad values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select c1 || ':' || c2 from c"), ",")
+	if got != "a|b:1,c:2,d:3" {
+		t.Errorf("bad values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select c1 || ':' || c2 from d"), ",")
+	if got != "Müller:Berlin,Meier:Hamburg" {
+		t.Errorf("bad values without header line: %s", got)
+	}
+	for _, want := range []string{
+		comma + `: separator ',', quote '"', header line true`,
+		tabs + `: separator '\t', quote '"', header line false`,
+		quotes + `: separator '|', quote '\'', header line false`,
+	} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("missing report %s in %s", want, log.String())
+		}
+	}
+}
+
+func TestLocale(t *testing.T) {
+	dir := t.TempDir()
+	de := writeTestFile(t, dir, "de.csv", "Name;Betrag;Tag\na;1.234,56;31.12.2021\nb;-7,5;1.2.2022\n")
+	mixed := writeTestFile(t, dir, "mixed.csv", "Name;Betrag;Tag\na;1,234.5;31.12.2021\n")
+	bad := writeTestFile(t, dir, "bad.csv", "Name;Betrag\na;1,5\nb;n/a\n")
+
+	var c = &Config{}
+	err := c.Parse([]string{"insert", de, "into", "a", "locale", "de",
+		"insert", mixed, "into", "b", "locale", "us", "locale", "de", "for", "Tag"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var m = &Musql{}
+	defer m.Close()
+	err = c.Apply(m)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select sum(Betrag) || ':' || max(Tag) from a"), ",")
+	if got != "1227.06:2022-02-01" {
+		t.Errorf("bad values: %s", got)
+	}
+	got = strings.Join(queryStrings(t, m, "select typeof(Betrag) || ':' || Betrag || ':' || Tag from b"), ",")
+	if got != "real:1234.5:2021-12-31" {
+		t.Errorf("bad values: %s", got)
+	}
+
+	err = m.AddCsvWithOptions("c", []FileInfo{FileInfo{Path: bad}}, CsvDialect{}, ImportOptions{
+		Locale:     "de",
+		KeepHeader: true,
+		Columns:    []Column{Column{Name: "Name"}, Column{Name: "Betrag", Type: typeReal}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "'n/a'") || !strings.Contains(err.Error(), "line 3 of "+bad) {
+		t.Errorf("expecting error for bad value with line number, got %v", err)
+	}
+
+	// inferred types: the values the locale can not parse are reported
+	err = m.AddCsvWithOptions("d", []FileInfo{FileInfo{Path: bad}}, CsvDialect{}, ImportOptions{Locale: "de"})
+	if err == nil || !strings.Contains(err.Error(), "'n/a'") || !strings.Contains(err.Error(), "line 3 of "+bad) {
+		t.Errorf("expecting error for bad value with line number, got %v", err)
+	}
+
+	// text columns keep their values
+	text := writeTestFile(t, dir, "text.csv", "Name;Code;Ort\nMüller;1.234;Berlin\nMeier;2.100;Hamburg\n")
+	err = m.AddCsvWithOptions("e", []FileInfo{FileInfo{Path: text}}, CsvDialect{}, ImportOptions{
+		Locale:     "de",
+		KeepHeader: true,
+		Columns:    []Column{Column{Name: "Name"}, Column{Name: "Code", Type: typeText}},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select Name || ':' || Code || ':' || Ort from e"), ",")
+	if got != "Müller:1.234:Berlin,Meier:2.100:Hamburg" {
+		t.Errorf("bad values: %s", got)
+	}
+	err = m.AddCsvWithOptions("f", []FileInfo{FileInfo{Path: text}}, CsvDialect{}, ImportOptions{
+		ColumnLocales: map[string]string{"Ort": "de"},
+	})
+	if err == nil {
+		t.Errorf("expecting error for text column with locale")
+	}
+
+	c = &Config{}
+	err = c.Parse([]string{"insert", de, "into", "a", "locale", "xx"})
+	if err == nil {
+		t.Errorf("expecting error for unknown locale")
+	}
+}
@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// columns added for the origin of the rows, the leading '_'
+// keeps them apart from the columns of the data
+var provenanceColumns = []string{"_file", "_container", "_row", "_imported"}
+var provenanceTypes = []string{typeText, typeText, typeInteger, typeDatetime}
+
+// origin of the imported rows
+type provenance struct {
+	imported string
+}
+
+func newProvenance(opts ImportOptions) *provenance {
+	if !opts.Provenance {
+		return nil
+	}
+	return &provenance{imported: time.Now().Format("2006-01-02 15:04:05")}
+}
+
+// append the provenance columns to the table columns
+func (p *provenance) columns(header []string, types []string) ([]string, []string) {
+	if p == nil {
+		return header, types
+	}
+	header = append(append([]string{}, header...), provenanceColumns...)
+	if types != nil {
+		types = append(append([]string{}, types...), provenanceTypes...)
+	}
+	return header, types
+}
+
+// append the provenance values of the n-th row (1-based) of the file
+func (p *provenance) values(row []interface{}, info FileInfo, n int) []interface{} {
+	if p == nil {
+		return row
+	}
+	var container interface{}
+	if info.Container != "" {
+		container = info.Container
+	}
+	return append(row, info.Path, container, n, p.imported)
+}
+
+// rowReader delivers the rows of one source file
+type rowReader interface {
+	// the header of the file, nil if the file has none
+	Header() []string
+	// the next row, io.EOF at the end of the file
+	Read() ([]string, error)
+	Close()
+}
+
+// rowReaders of text files also tell the line of the last row
+type lineCounter interface {
+	lineNumber() int
+}
+
+// the position of the n-th row for error messages
+func rowPosition(r rowReader, n int) string {
+	if lc, ok := r.(lineCounter); ok {
+		return fmt.Sprintf("line %d", lc.lineNumber())
+	}
+	return fmt.Sprintf("row %d", n)
+}
+
+// opens a source file for reading its rows
+type rowOpener func(info FileInfo) (rowReader, error)
+
+// expand the glob patterns of the file list, of the containers
+// and of the file names inside the containers
+func globFiles(path []FileInfo) ([]FileInfo, error) {
+	var files []FileInfo
+	for _, fileinfo := range path {
+		if fileinfo.Command || isURL(fileinfo.Path) || (fileinfo.Path == stdinName && fileinfo.Container == "") {
+			files = append(files, fileinfo)
+			continue
+		}
+		patt := fileinfo.Path
+		if fileinfo.Container != "" {
+			patt = fileinfo.Container
+		}
+		flist, err := filepath.Glob(patt)
+		if err != nil {
+			return nil, err
+		}
+		if len(flist) == 0 {
+			return nil, fmt.Errorf("file " + patt + " not found")
+		}
+		if fileinfo.Container == "" {
+			for _, fname := range flist {
+				files = append(files, FileInfo{Path: fname, Encoding: fileinfo.Encoding})
+			}
+			continue
+		}
+		if !hasGlob(fileinfo.Path) {
+			for _, fname := range flist {
+				files = append(files, FileInfo{Path: fileinfo.Path, Container: fname, Encoding: fileinfo.Encoding})
+			}
+			continue
+		}
+		// pattern for the members of the containers
+		found := false
+		for _, fname := range flist {
+			members, err := listMembers(fname)
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range members {
+				if matchGlob(fileinfo.Path, member) {
+					files = append(files, FileInfo{Path: member, Container: fname, Encoding: fileinfo.Encoding})
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no file " + fileinfo.Path + " found in " + patt)
+		}
+	}
+	return files, nil
+}
+
+// read the header and the first rows to set up the table columns
+func sampleColumns(info FileInfo, open rowOpener, opts ImportOptions) (*colmap, error) {
+	r, err := open(info)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var sample [][]string
+	for !opts.Untyped && len(sample) < sampleRows {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading %s", err, info.Path)
+		}
+		sample = append(sample, row)
+	}
+	cm, err := newColmap(r.Header(), sample, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: columns of %s", err, info.Path)
+	}
+	return cm, nil
+}
+
+func addRowFileToTable(info FileInfo, open rowOpener, insert *sql.Stmt, cm *colmap, prov *provenance) error {
+	r, err := open(info)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if header := r.Header(); header != nil {
+		err = verifyHeader(cm.source, header)
+		if err != nil {
+			return err
+		}
+	}
+	n := 0
+	for err == nil {
+		var row []string
+		row, err = r.Read()
+		if err == nil {
+			n++
+			var values []interface{}
+			values, err = cm.row(row)
+			if err != nil {
+				return fmt.Errorf("%w: %s of %s", err, rowPosition(r, n), info.Path)
+			}
+			_, err = insert.Exec(prov.values(values, info, n)...)
+		}
+	}
+	if err == io.EOF {
+		return nil
+	}
+	err = fmt.Errorf("%w: fill table from %s", err, info.Path)
+	return err
+}
+
+// fill the table with the rows of all files, the first file
+// defines the columns and their types
+func (m *Musql) addRowFiles(tablename string, path []FileInfo, open rowOpener, opts ImportOptions) error {
+	files, err := globFiles(path)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files for " + tablename)
+	}
+	cm, err := sampleColumns(files[0], open, opts)
+	if err != nil {
+		return err
+	}
+	prov := newProvenance(opts)
+	header, types := prov.columns(cm.header, cm.types)
+	err = prepareTable(m.db, tablename, header, types, opts)
+	if err != nil {
+		return err
+	}
+	have, err := tableColumnSet(m.db, tablename)
+	if err != nil {
+		return err
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	insert, err := makeInsert(tx, tablename, header, opts.upsertKeys())
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		fcm := cm
+		finsert := insert
+		if opts.MergeColumns && i > 0 {
+			// the columns of every file are mapped by name
+			fcm, err = sampleColumns(f, open, opts)
+			if err != nil {
+				return err
+			}
+			fheader, ftypes := prov.columns(fcm.header, fcm.types)
+			err = addMissingColumns(tx, tablename, have, fheader, ftypes)
+			if err != nil {
+				return err
+			}
+			finsert, err = makeInsert(tx, tablename, fheader, opts.upsertKeys())
+			if err != nil {
+				return err
+			}
+		}
+		err = addRowFileToTable(f, open, finsert, fcm, prov)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
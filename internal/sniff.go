@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// separators and quote characters tried when sniffing csv files
+var sniffSeparators = []rune{';', ',', '\t', '|'}
+var sniffQuotes = []rune{'"', '\''}
+
+// number of rows looked at to detect the csv dialect
+const sniffRows = 50
+
+// split the sample into records, nil if it is not valid with the separator and quote
+func sniffRecords(sample []byte, sep rune, quote rune) [][]string {
+	var r io.Reader = bytes.NewReader(sample)
+	if quote != '"' {
+		r = &quoteSwapper{r: r, quote: byte(quote)}
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = sep
+	cr.FieldsPerRecord = -1
+	var records [][]string
+	for len(records) < sniffRows {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// the share of records with the most common number of fields, and that number
+func sniffConsistency(records [][]string) (float64, int) {
+	counts := make(map[int]int)
+	best := 0
+	for _, rec := range records {
+		counts[len(rec)]++
+		if counts[len(rec)] > counts[best] || (counts[len(rec)] == counts[best] && len(rec) > best) {
+			best = len(rec)
+		}
+	}
+	if len(records) == 0 {
+		return 0, 0
+	}
+	return float64(counts[best]) / float64(len(records)), best
+}
+
+// fields enclosed in the quote character
+func quotedFields(sample []byte, quote rune) int {
+	n := 0
+	for _, line := range bytes.Split(sample, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		for _, sep := range sniffSeparators {
+			n += bytes.Count(line, []byte(string(sep)+string(quote)))
+			n += bytes.Count(line, []byte(string(quote)+string(sep)))
+		}
+		if bytes.HasPrefix(line, []byte(string(quote))) {
+			n++
+		}
+		if bytes.HasSuffix(line, []byte(string(quote))) {
+			n++
+		}
+	}
+	return n
+}
+
+// a header line has names: no empty, duplicate, numeric or date values.
+// The names also have to differ from the rows below: a column of numbers
+// or dates, or of text of one length, votes for a header line if the name
+// does not fit. Without votes (only text columns) there is no header line.
+func sniffHeader(records [][]string) bool {
+	if len(records) == 0 {
+		return true
+	}
+	seen := make(map[string]bool)
+	for _, h := range records[0] {
+		if h == "" || seen[h] || isReal(h) || isDatetime(h) {
+			return false
+		}
+		seen[h] = true
+	}
+	if len(records) == 1 {
+		return true
+	}
+	first, rows := records[0], records[1:]
+	types := inferTypes(len(first), rows)
+	votes := 0
+	for i, h := range first {
+		if types[i] != typeText {
+			votes++
+			continue
+		}
+		// text columns: one length for (more than one) values?
+		length, values := -1, 0
+		for _, row := range rows {
+			if i >= len(row) || row[i] == "" {
+				continue
+			}
+			n := utf8.RuneCountInString(row[i])
+			if length >= 0 && n != length {
+				length = -2
+				break
+			}
+			length = n
+			values++
+		}
+		if length >= 0 && values > 1 {
+			if utf8.RuneCountInString(h) != length {
+				votes++
+			} else {
+				votes--
+			}
+		}
+	}
+	return votes > 0
+}
+
+// detect separator, quote character and header line of a csv file from
+// its first bytes. Without a header line the column names are c1..cN.
+func sniffCsv(sample []byte, complete bool) (rune, rune, []string) {
+	if !complete {
+		// the last line may be cut
+		if i := bytes.LastIndexByte(sample, '\n'); i >= 0 {
+			sample = sample[:i+1]
+		}
+	}
+	sep, quote := ';', '"'
+	var records [][]string
+	bestScore, bestFields := 0.0, 1
+	for _, q := range sniffQuotes {
+		if q != '"' && quotedFields(sample, q) <= quotedFields(sample, '"') {
+			continue
+		}
+		for _, s := range sniffSeparators {
+			recs := sniffRecords(sample, s, q)
+			score, fields := sniffConsistency(recs)
+			if fields < 2 {
+				continue
+			}
+			if score > bestScore || (score == bestScore && fields > bestFields) {
+				sep, quote, records = s, q, recs
+				bestScore, bestFields = score, fields
+			}
+		}
+	}
+	if sniffHeader(records) {
+		return sep, quote, nil
+	}
+	var header []string
+	for i := 1; i <= bestFields; i++ {
+		header = append(header, fmt.Sprintf("c%d", i))
+	}
+	return sep, quote, header
+}
@@ -0,0 +1,578 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/antchfx/jsonquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"io"
+	"sort"
+	"strings"
+)
+
+// TreeOptions control how xml and json documents are read
+type TreeOptions struct {
+	// namespace uri of the prefixes used in the xpath expressions
+	Namespaces map[string]string
+	// column names with the namespace prefix (p:name)
+	KeepPrefixes bool
+	// repeated elements go into child tables
+	Children bool
+}
+
+// how recFlattenNode names the columns and handles repeated elements
+type flattenOptions struct {
+	// column names with the namespace prefix
+	prefixes bool
+	// paths of the repeated elements, collected in children (by path)
+	// instead of being flattened into the row
+	repeated map[string]bool
+	children map[string][]xpath.NodeNavigator
+	// how other repeated elements are stored in the row
+	repeat string
+	delim  string
+	// paths of elements repeated in other rows, stored the same way
+	// when they occur only once
+	repeats map[string]bool
+}
+
+// storing repeated elements (or several nodes found for a select) in the row
+const (
+	// a column per element: item/1, item/2
+	RepeatIndex = "index"
+	// the values of the elements joined with a delimiter
+	RepeatJoin = "join"
+	// only the first element
+	RepeatFirst = "first"
+	// the elements as json array
+	RepeatJSON = "json"
+)
+
+// generated ids of the rows of the parent and child tables
+const (
+	idColumn       = "_id"
+	parentIDColumn = "_parent_id"
+)
+
+// parse a namespace declaration: <prefix>=<uri>
+func ParseNamespace(spec string) (string, string, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return "", "", fmt.Errorf("bad namespace declaration '%s'", spec)
+	}
+	return kv[0], kv[1], nil
+}
+
+// the xpath library compares the prefixes of the document, so the
+// nodes of the declared namespaces get the declared prefixes
+// (also the nodes in a default namespace)
+func bindNamespaces(n *xmlquery.Node, prefixes map[string]string) {
+	for ; n != nil; n = n.NextSibling {
+		if n.Type == xmlquery.ElementNode {
+			if p, ok := prefixes[n.NamespaceURI]; ok {
+				n.Prefix = p
+			}
+			for i, a := range n.Attr {
+				if p, ok := prefixes[a.NamespaceURI]; ok && a.Name.Space != "xmlns" {
+					n.Attr[i].Name.Space = p
+				}
+			}
+		}
+		bindNamespaces(n.FirstChild, prefixes)
+	}
+}
+
+// parse a xml or json document
+func parseTree(r io.Reader, kind string, topts TreeOptions) (xpath.NodeNavigator, error) {
+	if kind != "xml" {
+		doc, err := jsonquery.Parse(r)
+		if err != nil {
+			return nil, err
+		}
+		return jsonquery.CreateXPathNavigator(doc), nil
+	}
+	doc, err := xmlquery.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(topts.Namespaces) > 0 {
+		prefixes := make(map[string]string)
+		for p, uri := range topts.Namespaces {
+			prefixes[uri] = p
+		}
+		bindNamespaces(doc, prefixes)
+	}
+	return xmlquery.CreateXPathNavigator(doc), nil
+}
+
+// the name of the node for the columns
+func nodeName(node xpath.NodeNavigator, prefixes bool) string {
+	if prefixes && node.Prefix() != "" {
+		return node.Prefix() + ":" + node.LocalName()
+	}
+	return node.LocalName()
+}
+
+// the child elements of the node by path (as in recFlattenNode)
+func childGroups(prefix string, node xpath.NodeNavigator, fo *flattenOptions) map[string][]xpath.NodeNavigator {
+	groups := make(map[string][]xpath.NodeNavigator)
+	child := node.Copy()
+	for ok := child.MoveToChild(); ok; ok = child.MoveToNext() {
+		if child.NodeType() == xpath.ElementNode {
+			cpath := prefix + nodeName(child, fo.prefixes)
+			groups[cpath] = append(groups[cpath], child.Copy())
+		}
+	}
+	return groups
+}
+
+// flatten repeated elements as set by the repeat option, name is the
+// column of the text of the elements
+func flattenRepeated(cpath string, name string, nodes []xpath.NodeNavigator, header map[string]int, data map[string]string, fo *flattenOptions) error {
+	if fo.repeat == RepeatFirst {
+		nodes = nodes[:1]
+	}
+	// the path is part of the column names without the index
+	prefix := ""
+	if fo.repeat == RepeatFirst || fo.repeat == RepeatJoin {
+		prefix = cpath
+	}
+	var found []map[string]string
+	for _, n := range nodes {
+		d := make(map[string]string)
+		err := recFlattenNode(prefix, n, make(map[string]int), d, name, fo)
+		if err != nil {
+			return err
+		}
+		found = append(found, d)
+	}
+	return addRepeated(name, found, header, data, fo)
+}
+
+// flatten all nodes found for a select
+func flattenSelected(nodes []xpath.NodeNavigator, name string, header map[string]int, data map[string]string, fo *flattenOptions) error {
+	if name == "" {
+		name = nodeName(nodes[0], fo.prefixes)
+	}
+	if nodes[0].NodeType() != xpath.AttributeNode {
+		return flattenRepeated("", name, nodes, header, data, fo)
+	}
+	var found []map[string]string
+	for _, n := range nodes {
+		found = append(found, map[string]string{name: n.Value()})
+	}
+	return addRepeated(name, found, header, data, fo)
+}
+
+// add the values of the repeated elements to the row
+func addRepeated(name string, found []map[string]string, header map[string]int, data map[string]string, fo *flattenOptions) error {
+	values := make(map[string]string)
+	switch fo.repeat {
+	case RepeatFirst:
+		values = found[0]
+	case RepeatIndex:
+		for i, d := range found {
+			for k, v := range d {
+				col := fmt.Sprintf("%s/%d", name, i+1)
+				if k != name {
+					col += "/" + k
+				}
+				values[col] = v
+			}
+		}
+	case RepeatJoin:
+		for _, d := range found {
+			for k, v := range d {
+				if prev, ok := values[k]; ok {
+					values[k] = prev + fo.delim + v
+				} else {
+					values[k] = v
+				}
+			}
+		}
+	case RepeatJSON:
+		var items []interface{}
+		for _, d := range found {
+			if v, ok := d[name]; ok && len(d) == 1 {
+				items = append(items, v)
+			} else {
+				items = append(items, d)
+			}
+		}
+		b, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		values[name] = string(b)
+	default:
+		return fmt.Errorf("unknown option '%s' for repeated elements", fo.repeat)
+	}
+	for k, v := range values {
+		if _, ok := header[k]; ok {
+			return fmt.Errorf("duplicate entry " + k)
+		}
+		header[k] = len(header)
+		data[k] = v
+	}
+	return nil
+}
+
+// mark the paths (as in recFlattenNode) of the elements repeated below
+// the node, the items of json arrays are always repeated
+func findRepeats(prefix string, node xpath.NodeNavigator, fo *flattenOptions) {
+	if prefix != "" {
+		prefix = prefix + "/"
+	}
+	count := make(map[string]int)
+	child := node.Copy()
+	for ok := child.MoveToChild(); ok; ok = child.MoveToNext() {
+		if child.NodeType() != xpath.ElementNode {
+			continue
+		}
+		name := nodeName(child, fo.prefixes)
+		cpath := prefix + name
+		count[cpath]++
+		if count[cpath] > 1 || name == "" {
+			fo.repeated[cpath] = true
+		}
+		findRepeats(cpath, child, fo)
+	}
+}
+
+// mark the paths of the elements repeated below the nodes of the
+// selects with a repeat option, so that the elements are stored the
+// same way in all rows
+func scanRepeats(node xpath.NodeNavigator, sels []compiledSelect, prefixes bool) {
+	for _, xsel := range sels {
+		if xsel.Repeat == "" {
+			continue
+		}
+		fo := &flattenOptions{prefixes: prefixes, repeated: xsel.repeats}
+		t := xsel.expr.Select(node.Copy())
+		for t.MoveNext() {
+			findRepeats("", t.Current(), fo)
+		}
+	}
+}
+
+// name of the child table for the repeated elements at the path
+func childTableName(tablename string, cpath string) string {
+	var segs []string
+	for _, s := range strings.Split(cpath, "/") {
+		if s != "" {
+			// plain sql names: p:line-item is p_line_item
+			segs = append(segs, strings.Map(func(r rune) rune {
+				if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+					return r
+				}
+				return '_'
+			}, s))
+		}
+	}
+	if len(segs) == 0 {
+		segs = []string{"item"}
+	}
+	return tablename + "_" + strings.Join(segs, "_")
+}
+
+// a table of a document split into parent and child tables
+type treeTable struct {
+	name   string
+	parent *treeTable
+	header map[string]int
+	rows   []treeRow
+}
+
+type treeRow struct {
+	id     int64
+	parent int64
+	data   map[string]string
+	// origin of the rows of the first table
+	info FileInfo
+	n    int
+}
+
+// flatten the nodes into a table, the repeated elements go into child tables
+func splitTree(tables *[]*treeTable, t *treeTable, nodes []xpath.NodeNavigator, rows []treeRow, usename string, prefixes bool) error {
+	fo := &flattenOptions{prefixes: prefixes, repeated: make(map[string]bool)}
+	for _, n := range nodes {
+		findRepeats("", n, fo)
+	}
+	*tables = append(*tables, t)
+	var cpaths []string
+	cnodes := make(map[string][]xpath.NodeNavigator)
+	crows := make(map[string][]treeRow)
+	for i, n := range nodes {
+		fo.children = make(map[string][]xpath.NodeNavigator)
+		header := make(map[string]int)
+		row := rows[i]
+		row.id = int64(len(t.rows) + 1)
+		row.data = make(map[string]string)
+		err := recFlattenNode("", n, header, row.data, usename, fo)
+		if err != nil {
+			return fmt.Errorf("%w: table %s", err, t.name)
+		}
+		mergemap(t.header, header)
+		t.rows = append(t.rows, row)
+		for cpath, children := range fo.children {
+			if _, ok := cnodes[cpath]; !ok {
+				cpaths = append(cpaths, cpath)
+			}
+			for _, c := range children {
+				cnodes[cpath] = append(cnodes[cpath], c)
+				crows[cpath] = append(crows[cpath], treeRow{parent: row.id})
+			}
+		}
+	}
+	sort.Strings(cpaths)
+	for _, cpath := range cpaths {
+		segs := strings.Split(strings.TrimSuffix(cpath, "/"), "/")
+		child := &treeTable{name: childTableName(t.name, cpath), parent: t, header: make(map[string]int)}
+		err := splitTree(tables, child, cnodes[cpath], crows[cpath], segs[len(segs)-1], prefixes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the largest id of the table (appending continues the ids)
+func maxID(db *sql.DB, tablename string, column string) (int64, error) {
+	columns, err := tableColumns(db, tablename)
+	if err != nil || columns == nil {
+		return 0, err
+	}
+	var id sql.NullInt64
+	err = db.QueryRow(fmt.Sprintf(`select max("%s") from "%s"`, column, tablename)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%w: reading ids of %s", err, tablename)
+	}
+	return id.Int64, nil
+}
+
+// import the selected nodes into the table, the repeated elements of
+// the nodes into child tables with the id of the parent row
+func (m *Musql) addTreeTables(tablename string, path []FileInfo, xpathstr string, xselects []Select, kind string, topts TreeOptions, opts ImportOptions) error {
+	if len(xselects) > 0 {
+		return fmt.Errorf("child tables of %s: selecting parts of the nodes ('using') is not supported", tablename)
+	}
+	if opts.Mode == ModeUpsert {
+		return fmt.Errorf("child tables of %s: upsert is not supported", tablename)
+	}
+	path, err := globFiles(path)
+	if err != nil {
+		return err
+	}
+	rexp, err := xpath.Compile(xpathstr)
+	if err != nil {
+		return err
+	}
+	var nodes []xpath.NodeNavigator
+	var rows []treeRow
+	for _, info := range path {
+		f, err := m.opencontainer(info)
+		if err != nil {
+			return err
+		}
+		doc, err := parseTree(f.file, kind, topts)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%w: reading %s", err, info.Path)
+		}
+		rt := rexp.Select(doc)
+		for n := 1; rt.MoveNext(); n++ {
+			nodes = append(nodes, rt.Current().Copy())
+			rows = append(rows, treeRow{info: info, n: n})
+		}
+	}
+	var tables []*treeTable
+	err = splitTree(&tables, &treeTable{name: tablename, header: make(map[string]int)}, nodes, rows, "", topts.KeepPrefixes)
+	if err != nil {
+		return err
+	}
+
+	prov := newProvenance(opts)
+	offsets := make(map[*treeTable]int64)
+	if opts.Mode == ModeAppend {
+		for _, t := range tables {
+			offsets[t], err = maxID(m.db, t.name, idColumn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range tables {
+		var header []string
+		for h := range t.header {
+			header = append(header, h)
+		}
+		sort.Strings(header)
+		columns := []string{idColumn}
+		types := []string{typeInteger}
+		if t.parent != nil {
+			columns = append(columns, parentIDColumn)
+			types = append(types, typeInteger)
+		}
+		columns = append(columns, header...)
+		for range header {
+			types = append(types, "")
+		}
+		if t.parent == nil {
+			columns, types = prov.columns(columns, types)
+		}
+		err = prepareTable(m.db, t.name, columns, types, opts)
+		if err != nil {
+			return err
+		}
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		insert, err := makeInsert(tx, t.name, columns, nil)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, r := range t.rows {
+			row := []interface{}{r.id + offsets[t]}
+			if t.parent != nil {
+				row = append(row, r.parent+offsets[t.parent])
+			}
+			for _, h := range header {
+				row = append(row, r.data[h])
+			}
+			if t.parent == nil {
+				row = prov.values(row, r.info, r.n)
+			}
+			_, err = insert.Exec(row...)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("%w: inserting into %s", err, t.name)
+			}
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columns of the node table of a document
+var treeHeader = []string{"id", "parent_id", "depth", "kind", "name", "value", "position", "path"}
+var treeTypes = []string{typeInteger, typeInteger, typeInteger, typeText, typeText, typeText, typeInteger, typeText}
+
+// walks a document and returns a row for every node
+type treeWalker struct {
+	prefixes bool
+	nextID   int64
+	rows     [][]interface{}
+}
+
+func (w *treeWalker) add(parent interface{}, depth int, kind string, name string, value interface{}, position int, path string) int64 {
+	w.nextID++
+	w.rows = append(w.rows, []interface{}{w.nextID, parent, depth, kind, name, value, position, path})
+	return w.nextID
+}
+
+// add the node, its attributes and its child elements, the value
+// of an element is its own text (without the text of the children)
+func (w *treeWalker) walk(node xpath.NodeNavigator, parent interface{}, depth int, position int, path string) {
+	kind := "element"
+	name := nodeName(node, w.prefixes)
+	if node.NodeType() == xpath.RootNode {
+		kind = "document"
+		name = ""
+	}
+	var text strings.Builder
+	child := node.Copy()
+	for ok := child.MoveToChild(); ok; ok = child.MoveToNext() {
+		if child.NodeType() == xpath.TextNode {
+			text.WriteString(child.Value())
+		}
+	}
+	var value interface{}
+	if strings.TrimSpace(text.String()) != "" {
+		value = text.String()
+	}
+	id := w.add(parent, depth, kind, name, value, position, path)
+	if path == "/" {
+		path = ""
+	}
+
+	attr := node.Copy()
+	for n := 1; attr.MoveToNextAttribute(); n++ {
+		aname := nodeName(attr, w.prefixes)
+		w.add(id, depth+1, "attribute", aname, attr.Value(), n, path+"/@"+aname)
+	}
+	count := make(map[string]int)
+	n := 0
+	child = node.Copy()
+	for ok := child.MoveToChild(); ok; ok = child.MoveToNext() {
+		if child.NodeType() != xpath.ElementNode {
+			continue
+		}
+		n++
+		cname := nodeName(child, w.prefixes)
+		count[cname]++
+		step := cname
+		if step == "" {
+			// items of json arrays
+			step = "*"
+		}
+		w.walk(child, id, depth+1, n, fmt.Sprintf("%s/%s[%d]", path, step, count[cname]))
+	}
+}
+
+// import every node of the documents as a row with the id of its parent
+func (m *Musql) AddTree(tablename string, path []FileInfo, kind string, topts TreeOptions, opts ImportOptions) error {
+	path, err := globFiles(path)
+	if err != nil {
+		return err
+	}
+	prov := newProvenance(opts)
+	columns, types := prov.columns(treeHeader, treeTypes)
+	var offset int64
+	if opts.Mode == ModeAppend {
+		offset, err = maxID(m.db, tablename, "id")
+		if err != nil {
+			return err
+		}
+	}
+	err = prepareTable(m.db, tablename, columns, types, opts)
+	if err != nil {
+		return err
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	insert, err := makeInsert(tx, tablename, columns, opts.upsertKeys())
+	if err != nil {
+		return err
+	}
+	w := &treeWalker{prefixes: topts.KeepPrefixes, nextID: offset}
+	for _, info := range path {
+		f, err := m.opencontainer(info)
+		if err != nil {
+			return err
+		}
+		doc, err := parseTree(f.file, kind, topts)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%w: reading %s", err, info.Path)
+		}
+		w.rows = nil
+		w.walk(doc, nil, 0, 1, "/")
+		for n, row := range w.rows {
+			_, err = insert.Exec(prov.values(row, info, n+1)...)
+			if err != nil {
+				return fmt.Errorf("%w: inserting into %s", err, tablename)
+			}
+		}
+	}
+	return nil
+}
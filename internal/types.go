@@ -0,0 +1,400 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// column types used when creating tables
+const (
+	typeText     = "TEXT"
+	typeInteger  = "INTEGER"
+	typeReal     = "REAL"
+	typeDate     = "DATE"
+	typeDatetime = "DATETIME"
+	typeBlob     = "BLOB"
+)
+
+// the column type in the table definition: dates are stored as TEXT,
+// the go driver would read DATE and DATETIME columns as time.Time
+// (and templates show "2021-12-31 00:00:00 +0000 UTC")
+func declaredType(coltype string) string {
+	if coltype == typeDate || coltype == typeDatetime {
+		return typeText
+	}
+	return coltype
+}
+
+// number of rows looked at to guess the column types
+const sampleRows = 1000
+
+// no leading zeros: "007" is an id, not a number
+var reInteger = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)$`)
+var reReal = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+var dateLayouts = []string{"2006-01-02"}
+var datetimeLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+func matchesLayout(layouts []string, s string) bool {
+	for _, l := range layouts {
+		if _, err := time.Parse(l, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isInteger(s string) bool {
+	if !reInteger.MatchString(s) {
+		return false
+	}
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+func isReal(s string) bool {
+	return reReal.MatchString(s)
+}
+
+func isDate(s string) bool {
+	return matchesLayout(dateLayouts, s)
+}
+
+func isDatetime(s string) bool {
+	return isDate(s) || matchesLayout(datetimeLayouts, s)
+}
+
+// guess the sqlite column types from some rows of data
+func inferTypes(ncols int, sample [][]string) []string {
+	const (
+		canInt = 1 << iota
+		canReal
+		canDate
+		canDatetime
+	)
+	possible := make([]int, ncols)
+	seen := make([]bool, ncols)
+	for i := range possible {
+		possible[i] = canInt | canReal | canDate | canDatetime
+	}
+	for _, row := range sample {
+		for i, val := range row {
+			if i >= ncols || val == "" {
+				continue
+			}
+			seen[i] = true
+			p := possible[i]
+			if p&canInt != 0 && !isInteger(val) {
+				p &^= canInt
+			}
+			if p&canReal != 0 && !isReal(val) {
+				p &^= canReal
+			}
+			if p&canDate != 0 && !isDate(val) {
+				p &^= canDate
+			}
+			if p&canDatetime != 0 && !isDatetime(val) {
+				p &^= canDatetime
+			}
+			possible[i] = p
+		}
+	}
+	types := make([]string, ncols)
+	for i, p := range possible {
+		switch {
+		case !seen[i]:
+			types[i] = typeText
+		case p&canInt != 0:
+			types[i] = typeInteger
+		case p&canReal != 0:
+			types[i] = typeReal
+		case p&canDate != 0:
+			types[i] = typeDate
+		case p&canDatetime != 0:
+			types[i] = typeDatetime
+		default:
+			types[i] = typeText
+		}
+	}
+	return types
+}
+
+// convert a text value to the go type matching the column type.
+// Values that do not fit (the sample did not show them) are kept as text,
+// empty values of non-text columns become NULL.
+func convertValue(coltype string, s string) interface{} {
+	if coltype == "" || coltype == typeText {
+		return s
+	}
+	if s == "" {
+		return nil
+	}
+	switch coltype {
+	case typeInteger:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if isReal(s) {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f
+			}
+		}
+	case typeReal:
+		if isReal(s) {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f
+			}
+		}
+	}
+	return s
+}
+
+// Column declares how a column of the source is stored in the table
+type Column struct {
+	// name of the column in the source
+	Name string
+	// sqlite column type, empty to infer the type from the data
+	Type string
+	// name of the table column, empty to use Name
+	Rename string
+	// do not store the column
+	Skip bool
+}
+
+// translate the type names of a column declaration to sqlite types
+func columnType(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		return "", nil
+	case "int", "integer":
+		return typeInteger, nil
+	case "real", "float", "double", "number", "numeric":
+		return typeReal, nil
+	case "text", "string":
+		return typeText, nil
+	case "date":
+		return typeDate, nil
+	case "datetime", "timestamp":
+		return typeDatetime, nil
+	}
+	return "", fmt.Errorf("unknown column type '%s'", name)
+}
+
+// parse a column declaration: skip | <name>[:<type>[:<rename>]]
+func ParseColumn(spec string) (Column, error) {
+	if spec == "skip" {
+		return Column{Skip: true}, nil
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return Column{}, fmt.Errorf("bad column declaration '%s'", spec)
+	}
+	c := Column{Name: parts[0]}
+	if len(parts) > 1 {
+		t, err := columnType(parts[1])
+		if err != nil {
+			return Column{}, fmt.Errorf("%w in '%s'", err, spec)
+		}
+		c.Type = t
+	}
+	if len(parts) > 2 {
+		c.Rename = parts[2]
+	}
+	return c, nil
+}
+
+// mapping of the source columns to the table columns
+type colmap struct {
+	// columns as found in the source
+	source []string
+	// columns of the table
+	header []string
+	// column types of the table, nil for untyped columns
+	types []string
+	// index into the source row for every table column
+	index []int
+	// locale of every table column, nil for the sqlite formats
+	locales []*Locale
+	// the source columns are the declared ones ('as header')
+	declared bool
+}
+
+// the locale of every source column, explicit if it is set for the column
+func sourceLocales(source []string, opts ImportOptions) ([]*Locale, []bool, error) {
+	locales := make([]*Locale, len(source))
+	explicit := make([]bool, len(source))
+	if opts.Locale == "" && len(opts.ColumnLocales) == 0 {
+		return locales, explicit, nil
+	}
+	for i, name := range source {
+		lname := opts.Locale
+		if l, ok := opts.ColumnLocales[name]; ok {
+			lname = l
+			explicit[i] = true
+		}
+		if lname == "" {
+			continue
+		}
+		l, err := ParseLocale(lname)
+		if err != nil {
+			return nil, nil, err
+		}
+		locales[i] = &l
+	}
+	return locales, explicit, nil
+}
+
+// infer the type of a column with a locale from the values the locale
+// can parse, the other values are reported when the rows are stored.
+// With mostly other values, a column of the table locale keeps its
+// type and values, a column with its own locale fails.
+func inferLocalized(name string, values []string, l *Locale, explicit bool, coltype string) (string, *Locale, error) {
+	var parsed [][]string
+	other := 0
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if n, ok := l.parse(v); ok {
+			parsed = append(parsed, []string{n})
+		} else {
+			other++
+		}
+	}
+	if len(parsed) == 0 && other == 0 {
+		return coltype, l, nil
+	}
+	ltype := typeText
+	if len(parsed) >= other || explicit {
+		ltype = inferTypes(1, parsed)[0]
+	}
+	if ltype == typeText {
+		if explicit {
+			return "", nil, fmt.Errorf("the values of column %s are no numbers or dates of locale %s", name, l.Name)
+		}
+		return coltype, nil, nil
+	}
+	return ltype, l, nil
+}
+
+// build the table columns from the source header, the column declarations
+// and a sample of the data used to infer the missing types.
+// With an empty source header the declarations name the columns.
+func newColmap(source []string, sample [][]string, opts ImportOptions) (*colmap, error) {
+	cols := opts.Columns
+	declared := len(source) == 0
+	if declared {
+		for _, c := range cols {
+			if c.Name == "" && c.Rename == "" && !c.Skip {
+				return nil, fmt.Errorf("missing column name in declaration")
+			}
+			source = append(source, c.Name)
+		}
+	}
+	if len(cols) > len(source) {
+		return nil, fmt.Errorf("%d columns declared, but only %d found (%s)", len(cols), len(source), strings.Join(source, ";"))
+	}
+	locales, explicit, err := sourceLocales(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	for name := range opts.ColumnLocales {
+		if opts.MergeColumns {
+			break
+		}
+		found := false
+		for _, s := range source {
+			found = found || s == name
+		}
+		if !found {
+			return nil, fmt.Errorf("no column '%s' for the locale", name)
+		}
+	}
+	inferred := inferTypes(len(source), sample)
+	cm := &colmap{source: source, declared: declared}
+	typed := false
+	for i, name := range source {
+		c := Column{Name: name}
+		if i < len(cols) {
+			c = cols[i]
+		}
+		if c.Skip {
+			continue
+		}
+		if c.Name != "" && c.Name != name {
+			return nil, fmt.Errorf("column %d is '%s', declared as '%s'", i+1, name, c.Name)
+		}
+		colname := name
+		if c.Rename != "" {
+			colname = c.Rename
+		}
+		coltype := c.Type
+		l := locales[i]
+		if coltype == "" && !opts.Untyped {
+			coltype = inferred[i]
+			if l != nil {
+				var values []string
+				for _, row := range sample {
+					if i < len(row) {
+						values = append(values, row[i])
+					}
+				}
+				coltype, l, err = inferLocalized(name, values, l, explicit[i], coltype)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if coltype == "" || coltype == typeText {
+			// text is stored as it is
+			l = nil
+		}
+		if coltype != "" {
+			typed = true
+		}
+		cm.header = append(cm.header, colname)
+		cm.types = append(cm.types, coltype)
+		cm.index = append(cm.index, i)
+		cm.locales = append(cm.locales, l)
+	}
+	if !typed {
+		cm.types = nil
+	}
+	return cm, nil
+}
+
+// pick and convert the values of a source row for the insert statement.
+// Values of (non text) columns with a locale must fit the column type.
+func (cm *colmap) row(values []string) ([]interface{}, error) {
+	if cm.declared && len(values) > len(cm.source) {
+		return nil, fmt.Errorf("%d values, but only %d columns declared", len(values), len(cm.source))
+	}
+	v := make([]interface{}, len(cm.index))
+	for i, si := range cm.index {
+		val := ""
+		if si < len(values) {
+			val = values[si]
+		}
+		if l := cm.locales[i]; l != nil && val != "" {
+			val, _ = l.parse(val)
+			if !fitsType(cm.types[i], val) {
+				return nil, fmt.Errorf("bad value '%s' for %s column %s (locale %s)", values[si], cm.types[i], cm.header[i], l.Name)
+			}
+		}
+		if cm.types != nil {
+			v[i] = convertValue(cm.types[i], val)
+		} else {
+			v[i] = val
+		}
+	}
+	return v, nil
+}
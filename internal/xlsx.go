@@ -0,0 +1,416 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsx files are zip archives of xml parts, only the parts
+// needed to read the cell values are looked at
+
+type xlsxWorkbook struct {
+	Pr struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	s := t.T
+	for _, r := range t.R {
+		s += r.T
+	}
+	return s
+}
+
+type xlsxSharedStrings struct {
+	SI []xlsxText `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxCell struct {
+	Ref   string   `xml:"r,attr"`
+	Type  string   `xml:"t,attr"`
+	Style int      `xml:"s,attr"`
+	V     string   `xml:"v"`
+	IS    xlsxText `xml:"is"`
+}
+
+type xlsxRow struct {
+	Ref   int        `xml:"r,attr"`
+	Cells []xlsxCell `xml:"c"`
+}
+
+// a cell range, zero values for open ends (rows are 1-based, columns 0-based)
+type xlsxRange struct {
+	minRow, maxRow int
+	minCol, maxCol int
+	hasMaxCol      bool
+}
+
+var reCellRef = regexp.MustCompile(`^([A-Za-z]*)([0-9]*)$`)
+
+// split a cell reference like "B12" into column (0-based) and row (1-based)
+func parseCellRef(ref string) (col int, row int, hascol bool, err error) {
+	m := reCellRef.FindStringSubmatch(ref)
+	if m == nil {
+		return 0, 0, false, fmt.Errorf("bad cell reference '%s'", ref)
+	}
+	for _, c := range strings.ToUpper(m[1]) {
+		col = col*26 + int(c-'A') + 1
+	}
+	hascol = col > 0
+	col--
+	if m[2] != "" {
+		row, _ = strconv.Atoi(m[2])
+	}
+	return col, row, hascol, nil
+}
+
+// parse ranges like "A2:D100", "B3" (open end) or "A2:D" (all rows)
+func parseXlsxRange(s string) (xlsxRange, error) {
+	var r xlsxRange
+	if s == "" {
+		return r, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	col, row, hascol, err := parseCellRef(parts[0])
+	if err != nil {
+		return r, err
+	}
+	if hascol {
+		r.minCol = col
+	}
+	r.minRow = row
+	if len(parts) == 2 {
+		col, row, hascol, err = parseCellRef(parts[1])
+		if err != nil {
+			return r, err
+		}
+		if hascol {
+			r.maxCol = col
+			r.hasMaxCol = true
+		}
+		r.maxRow = row
+	}
+	return r, nil
+}
+
+func (r xlsxRange) containsRow(row int) bool {
+	return row >= r.minRow && (r.maxRow == 0 || row <= r.maxRow)
+}
+
+func (r xlsxRange) containsCol(col int) bool {
+	return col >= r.minCol && (!r.hasMaxCol || col <= r.maxCol)
+}
+
+func readZipXml(files map[string]*zip.File, name string, v interface{}) error {
+	zf, ok := files[name]
+	if !ok {
+		return fmt.Errorf("missing %s", name)
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// built in number formats showing dates or times
+func isDateFormatID(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+var reFormatLiterals = regexp.MustCompile(`"[^"]*"|\[[^\]]*\]|\\.`)
+
+func isDateFormatCode(code string) bool {
+	code = strings.ToLower(reFormatLiterals.ReplaceAllString(code, ""))
+	return strings.ContainsAny(code, "ymdhs")
+}
+
+// convert the excel serial date number to an iso date
+func xlsxDate(v string, date1904 bool) string {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return v
+	}
+	base := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		base = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if f < 60 {
+		// excel counts the non existing 1900-02-29
+		base = base.AddDate(0, 0, 1)
+	}
+	days := math.Floor(f)
+	secs := math.Round((f - days) * 86400)
+	t := base.AddDate(0, 0, int(days)).Add(time.Duration(secs) * time.Second)
+	if days == 0 && !date1904 {
+		return t.Format("15:04:05")
+	}
+	if secs == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+type xlsxRows struct {
+	header []string
+	rows   [][]string
+	pos    int
+}
+
+// read the cells of a sheet (by name or 1-based index, the first sheet if empty).
+// The first row of the range is the header unless header is false.
+func (m *Musql) openXlsx(info FileInfo, sheet string, cellrange string, header bool) (*xlsxRows, error) {
+	rng, err := parseXlsxRange(cellrange)
+	if err != nil {
+		return nil, err
+	}
+	f, err := m.opencontainer(info)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(f.file)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading %s", err, info.Path)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: opening %s", err, info.Path)
+	}
+	files := make(map[string]*zip.File)
+	for _, zf := range zr.File {
+		files[zf.Name] = zf
+	}
+
+	var wb xlsxWorkbook
+	err = readZipXml(files, "xl/workbook.xml", &wb)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading workbook of %s", err, info.Path)
+	}
+	var rels xlsxRelationships
+	err = readZipXml(files, "xl/_rels/workbook.xml.rels", &rels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading workbook of %s", err, info.Path)
+	}
+	var sst xlsxSharedStrings
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		err = readZipXml(files, "xl/sharedStrings.xml", &sst)
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading strings of %s", err, info.Path)
+		}
+	}
+	var styles xlsxStyles
+	if _, ok := files["xl/styles.xml"]; ok {
+		err = readZipXml(files, "xl/styles.xml", &styles)
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading styles of %s", err, info.Path)
+		}
+	}
+	customDates := make(map[int]bool)
+	for _, nf := range styles.NumFmts {
+		customDates[nf.ID] = isDateFormatCode(nf.Code)
+	}
+	isDateStyle := func(s int) bool {
+		if s < 0 || s >= len(styles.CellXfs) {
+			return false
+		}
+		id := styles.CellXfs[s].NumFmtID
+		if d, ok := customDates[id]; ok {
+			return d
+		}
+		return isDateFormatID(id)
+	}
+
+	// find the sheet
+	rid := ""
+	for i, s := range wb.Sheets {
+		if s.Name == sheet || (sheet == "" && i == 0) {
+			rid = s.RID
+			break
+		}
+	}
+	if rid == "" {
+		if n, err := strconv.Atoi(sheet); err == nil && n >= 1 && n <= len(wb.Sheets) {
+			rid = wb.Sheets[n-1].RID
+		}
+	}
+	if rid == "" {
+		return nil, fmt.Errorf("no sheet '%s' in %s", sheet, info.Path)
+	}
+	target := ""
+	for _, r := range rels.Relationships {
+		if r.ID == rid {
+			target = r.Target
+		}
+	}
+	if strings.HasPrefix(target, "/") {
+		target = target[1:]
+	} else {
+		target = path.Join("xl", target)
+	}
+	zf, ok := files[target]
+	if !ok {
+		return nil, fmt.Errorf("missing sheet '%s' (%s) in %s", sheet, target, info.Path)
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// read the rows of the sheet one by one
+	var rows [][]string
+	width := 0
+	rownum := 0
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading sheet '%s' of %s", err, sheet, info.Path)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "row" {
+			continue
+		}
+		var xr xlsxRow
+		err = dec.DecodeElement(&xr, &se)
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading sheet '%s' of %s", err, sheet, info.Path)
+		}
+		rownum++
+		if xr.Ref != 0 {
+			rownum = xr.Ref
+		}
+		if !rng.containsRow(rownum) {
+			continue
+		}
+		var row []string
+		empty := true
+		col := -1
+		for _, c := range xr.Cells {
+			col++
+			if c.Ref != "" {
+				col, _, _, err = parseCellRef(c.Ref)
+				if err != nil {
+					return nil, fmt.Errorf("%w: reading sheet '%s' of %s", err, sheet, info.Path)
+				}
+			}
+			if !rng.containsCol(col) {
+				continue
+			}
+			val := c.V
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.V)
+				if err != nil || idx < 0 || idx >= len(sst.SI) {
+					return nil, fmt.Errorf("bad shared string %s in cell %s of %s", c.V, c.Ref, info.Path)
+				}
+				val = sst.SI[idx].String()
+			case "inlineStr":
+				val = c.IS.String()
+			case "", "n":
+				if val != "" && isDateStyle(c.Style) {
+					val = xlsxDate(val, wb.Pr.Date1904)
+				}
+			}
+			i := col - rng.minCol
+			for len(row) <= i {
+				row = append(row, "")
+			}
+			row[i] = val
+			if val != "" {
+				empty = false
+			}
+		}
+		if empty {
+			continue
+		}
+		if len(row) > width {
+			width = len(row)
+		}
+		rows = append(rows, row)
+	}
+	if rng.hasMaxCol {
+		width = rng.maxCol - rng.minCol + 1
+	}
+	for i := range rows {
+		for len(rows[i]) < width {
+			rows[i] = append(rows[i], "")
+		}
+	}
+
+	x := &xlsxRows{rows: rows}
+	if header {
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("no header in sheet '%s' of %s", sheet, info.Path)
+		}
+		x.header = rows[0]
+		x.pos = 1
+	}
+	return x, nil
+}
+
+func (x *xlsxRows) Header() []string {
+	return x.header
+}
+
+func (x *xlsxRows) Read() ([]string, error) {
+	if x.pos >= len(x.rows) {
+		return nil, io.EOF
+	}
+	x.pos++
+	return x.rows[x.pos-1], nil
+}
+
+func (x *xlsxRows) Close() {
+}
+
+func (m *Musql) AddXlsx(tablename string, path []FileInfo, sheet string, cellrange string, opts ImportOptions) error {
+	header := len(opts.Columns) == 0 || opts.KeepHeader
+	open := func(info FileInfo) (rowReader, error) {
+		return m.openXlsx(info, sheet, cellrange, header)
+	}
+	return m.addRowFiles(tablename, path, open, opts)
+}
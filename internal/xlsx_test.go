@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestZip(t *testing.T, fname string, parts map[string]string) {
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		w.Write([]byte(content))
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestXlsx(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "report.xlsx")
+	writeTestZip(t, fname, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="Info" sheetId="1" r:id="rId1"/><sheet name="Data" sheetId="2" r:id="rId2"/></sheets>
+		</workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships>
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+			<Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/>
+		</Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>Name</t></si><si><t>Wert</t></si><si><r><t>Tag</t></r></si><si><t>a</t></si></sst>`,
+		"xl/styles.xml":        `<styleSheet><cellXfs><xf numFmtId="0"/><xf numFmtId="14"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+			<row r="1"><c r="A1" t="inlineStr"><is><t>nothing here</t></is></c></row>
+		</sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet><sheetData>
+			<row r="1"><c r="A1" t="inlineStr"><is><t>Title</t></is></c></row>
+			<row r="3"><c r="B3" t="s"><v>0</v></c><c r="C3" t="s"><v>1</v></c><c r="D3" t="s"><v>2</v></c></row>
+			<row r="4"><c r="B4" t="s"><v>3</v></c><c r="C4"><v>30000</v></c><c r="D4" s="1"><v>44561</v></c></row>
+			<row r="5"><c r="B5" t="inlineStr"><is><t>b</t></is></c><c r="C5"><v>4000</v></c></row>
+		</sheetData></worksheet>`,
+	})
+
+	var m = &Musql{}
+	m.NewDb()
+	defer m.Close()
+	err := m.AddXlsx("x", []FileInfo{FileInfo{Path: fname}}, "Data", "B3:D", ImportOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Join(queryStrings(t, m, "select Name || ':' || Wert || ':' || coalesce(Tag, '-') from x order by Wert"), ",")
+	if got != "b:4000:-,a:30000:2021-12-31" {
+		t.Errorf("bad rows: %s", got)
+	}
+
+	err = m.AddXlsx("y", []FileInfo{FileInfo{Path: fname}}, "1", "", ImportOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = strings.Join(queryStrings(t, m, "select count(*) from y"), ",")
+	if got != "0" {
+		t.Errorf("bad row count: %s", got)
+	}
+
+	err = m.AddXlsx("z", []FileInfo{FileInfo{Path: fname}}, "Missing", "", ImportOptions{})
+	if err == nil {
+		t.Errorf("expecting error for missing sheet")
+	}
+}